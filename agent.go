@@ -0,0 +1,227 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+const (
+	AgentVolumeName       = "testpod-agent"
+	AgentContainerName    = "testpod-agent"
+	AgentDir              = "/testpod-agent"
+	AgentPath             = AgentDir + "/testpod"
+	DefaultAgentInitImage = "busybox"
+
+	OSLabel   = "kubernetes.io/os"
+	ArchLabel = "kubernetes.io/arch"
+)
+
+// elfMachineArchs maps elf machines to the architecture names used by go and the kubernetes.io/arch label
+var elfMachineArchs = map[elf.Machine]string{
+	elf.EM_X86_64:  "amd64",
+	elf.EM_386:     "386",
+	elf.EM_AARCH64: "arm64",
+	elf.EM_ARM:     "arm",
+	elf.EM_PPC64:   "ppc64le",
+	elf.EM_S390:    "s390x",
+	elf.EM_RISCV:   "riscv64",
+}
+
+func getAgentBinaryPath(arch string) string {
+	return filepath.Join(xdg.ConfigHome, "testpod", "agent", "testpod-linux-"+arch)
+}
+
+// ResolveAgentBinary returns the path and architecture of a static linux build of testpod. the own binary is preferred, followed by builds in the config dir. an empty nodeArch accepts any architecture
+func ResolveAgentBinary(override, nodeArch string) (string, string, error) {
+	if len(override) > 0 {
+		arch, err := readAgentBinaryArch(override)
+		if err != nil {
+			return "", "", usageErrorf("invalid agent binary: %w", err)
+		}
+		if len(nodeArch) > 0 && arch != nodeArch {
+			return "", "", usageErrorf("agent binary %s is built for %s, but the node runs %s", override, arch, nodeArch)
+		}
+		return override, arch, nil
+	}
+
+	candidates := make([]string, 0)
+	if self, err := os.Executable(); err == nil {
+		candidates = append(candidates, self)
+	}
+	if len(nodeArch) > 0 {
+		candidates = append(candidates, getAgentBinaryPath(nodeArch))
+	} else if matches, err := filepath.Glob(getAgentBinaryPath("*")); err == nil {
+		sort.Strings(matches)
+		candidates = append(candidates, matches...)
+	}
+
+	reasons := make([]string, 0, len(candidates))
+	for _, path := range candidates {
+		arch, err := readAgentBinaryArch(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				reasons = append(reasons, err.Error())
+			}
+			continue
+		}
+		if len(nodeArch) == 0 || arch == nodeArch {
+			return path, arch, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s is built for %s", path, arch))
+	}
+
+	archName := nodeArch
+	if len(archName) == 0 {
+		archName = "<arch>"
+	}
+	msg := fmt.Sprintf("no static linux build of testpod found to use as agent. use --agent-binary or place a build at %s", getAgentBinaryPath(archName))
+	if len(reasons) > 0 {
+		msg += " (" + strings.Join(reasons, "; ") + ")"
+	}
+	return "", "", usageErrorf("%s", msg)
+}
+
+// readAgentBinaryArch only accepts statically linked binaries, as the images of the pods may not provide any libraries
+func readAgentBinaryArch(path string) (string, error) {
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a linux binary", path)
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			return "", fmt.Errorf("%s is not statically linked, build it with CGO_ENABLED=0", path)
+		}
+	}
+	arch, ok := elfMachineArchs[f.Machine]
+	if !ok {
+		return "", fmt.Errorf("%s is built for unsupported machine %s", path, f.Machine)
+	}
+	return arch, nil
+}
+
+// injectAgent streams the agent binary to the init container added by addAgentInitContainer
+func injectAgent(podName, binaryPath string) error {
+	data, err := os.ReadFile(binaryPath)
+	if err != nil {
+		return fmt.Errorf("read agent binary: %w", err)
+	}
+
+	if err := kubectlWaitForContainer(podName, AgentContainerName, 30*time.Second); err != nil {
+		return err
+	}
+	fmt.Fprintln(infoOut, "inject testpod agent")
+	if err := kubectlAttachStdin(podName, AgentContainerName, string(data)); err != nil {
+		return fmt.Errorf("attach to init container: %w", err)
+	}
+	return nil
+}
+
+func waitForTermination() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+}
+
+// execAgentSleep keeps the pod running until it is deleted, as the agent might be the only binary in the pod
+func execAgentSleep() error {
+	waitForTermination()
+	return nil
+}
+
+func execAgentListen(protocol string, port int) error {
+	addr, closer, err := ListenEcho(protocol, fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	fmt.Fprintf(os.Stderr, "echo %s on %s\n", protocol, addr)
+	waitForTermination()
+	return nil
+}
+
+// ListenEcho sends everything back that is received on the address until the returned closer is closed
+func ListenEcho(protocol, address string) (net.Addr, io.Closer, error) {
+	if protocol == "UDP" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			buf := make([]byte, 64*1024)
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				conn.WriteTo(buf[:n], addr)
+			}
+		}()
+		return conn.LocalAddr(), conn, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr(), listener, nil
+}
+
+// execAgentGet writes the file to stdout for downloads with testpod cp
+func execAgentGet(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// execAgentPut writes stdin to the file for uploads with testpod cp
+func execAgentPut(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, os.Stdin); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ParseCopyPath splits paths like pod:/tmp/file. local paths return an empty pod name. single letters are windows drives like C:\file
+func ParseCopyPath(str string) (string, string) {
+	i := strings.Index(str, ":")
+	if i <= 1 || strings.ContainsAny(str[:i], `/\`) {
+		return "", str
+	}
+	return str[:i], str[i+1:]
+}
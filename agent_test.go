@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCopyPath(t *testing.T) {
+	pod, path := ParseCopyPath("testpod-dev-20261018-120000:/tmp/dump.sql")
+	require.Equal(t, "testpod-dev-20261018-120000", pod)
+	require.Equal(t, "/tmp/dump.sql", path)
+
+	pod, path = ParseCopyPath("./dump.sql")
+	require.Empty(t, pod)
+	require.Equal(t, "./dump.sql", path)
+
+	pod, path = ParseCopyPath("dir/file:with-colon")
+	require.Empty(t, pod)
+	require.Equal(t, "dir/file:with-colon", path)
+
+	pod, path = ParseCopyPath(`C:\Users\dev\dump.sql`)
+	require.Empty(t, pod)
+	require.Equal(t, `C:\Users\dev\dump.sql`, path)
+}
+
+func TestSplitShellWords(t *testing.T) {
+	words, err := SplitShellWords(`  ls -l   "/tmp/some dir" 'it''s' a\ b ""`)
+	require.NoError(t, err)
+	require.Equal(t, []string{"ls", "-l", "/tmp/some dir", "its", "a b", ""}, words)
+
+	_, err = SplitShellWords(`echo "unterminated`)
+	require.Error(t, err)
+}
+
+func TestAgentShell(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(wd)
+
+	var out bytes.Buffer
+	in := strings.NewReader("cd " + dir + "\nmkdir sub/dir\nls\necho hello   world\nunknown-command-xyz\nexit\necho never\n")
+	require.NoError(t, agentShell{in: in, out: &out}.Run())
+	require.Contains(t, out.String(), "sub\n")
+	require.Contains(t, out.String(), "hello world\n")
+	require.Contains(t, out.String(), "ERR: ")
+	require.NotContains(t, out.String(), "never")
+	require.DirExists(t, filepath.Join(dir, "sub", "dir"))
+}
+
+func TestResolveAgentBinaryOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testpod")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"), 0755))
+	_, _, err := ResolveAgentBinary(path, "")
+	require.Error(t, err)
+	require.Equal(t, ExitCodeUsageError, getExitCode(err))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// agentShell is a minimal shell for images without any shell. other binaries of the image can still be run by name or path
+type agentShell struct {
+	in  io.Reader
+	out io.Writer
+}
+
+const agentShellHelp = `builtin commands:
+  cd [dir]                         change the working directory
+  pwd                              print the working directory
+  ls [-l] [path...]                list directory contents
+  cat <file...>                    print files
+  echo [arg...]                    print arguments
+  env                              print the environment
+  mkdir <dir...>                   create directories including parents
+  rm [-r] <path...>                remove files or directories
+  ps                               list processes
+  hostname                         print the hostname
+  probe <kind> <target> [timeout]  run a dns, tcp, http or tls probe
+  listen <port> [TCP|UDP]          echo everything received on the port until ctrl+d
+  exit                             leave the shell
+other commands are run as programs of the image`
+
+func execAgentShell() error {
+	return agentShell{in: os.Stdin, out: os.Stdout}.Run()
+}
+
+func (sh agentShell) Run() error {
+	scanner := bufio.NewScanner(sh.in)
+	for {
+		wd, _ := os.Getwd()
+		fmt.Fprintf(sh.out, "agent:%s$ ", wd)
+		if !scanner.Scan() {
+			fmt.Fprintln(sh.out)
+			return scanner.Err()
+		}
+		args, err := SplitShellWords(scanner.Text())
+		if err != nil {
+			fmt.Fprintln(sh.out, "ERR:", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" {
+			return nil
+		}
+		if err := sh.runCommand(args); err != nil {
+			fmt.Fprintln(sh.out, "ERR:", err)
+		}
+	}
+}
+
+func (sh agentShell) runCommand(args []string) error {
+	switch args[0] {
+	case "help":
+		fmt.Fprintln(sh.out, agentShellHelp)
+		return nil
+
+	case "cd":
+		dir := "/"
+		if len(args) > 1 {
+			dir = args[1]
+		}
+		return os.Chdir(dir)
+
+	case "pwd":
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, wd)
+		return nil
+
+	case "ls":
+		return sh.ls(args[1:])
+
+	case "cat":
+		for _, path := range args[1:] {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(sh.out, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "echo":
+		fmt.Fprintln(sh.out, strings.Join(args[1:], " "))
+		return nil
+
+	case "env":
+		for _, e := range os.Environ() {
+			fmt.Fprintln(sh.out, e)
+		}
+		return nil
+
+	case "mkdir":
+		for _, dir := range args[1:] {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "rm":
+		recursive := len(args) > 1 && args[1] == "-r"
+		paths := args[1:]
+		if recursive {
+			paths = args[2:]
+		}
+		for _, path := range paths {
+			remove := os.Remove
+			if recursive {
+				remove = os.RemoveAll
+			}
+			if err := remove(path); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "ps":
+		return sh.ps()
+
+	case "hostname":
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, hostname)
+		return nil
+
+	case "probe":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: probe <kind> <target> [timeout]")
+		}
+		timeout := 5 * time.Second
+		if len(args) > 3 {
+			var err error
+			if timeout, err = time.ParseDuration(args[3]); err != nil {
+				return err
+			}
+		}
+		return RunProbe(args[1], args[2], timeout).Write(sh.out, time.Now())
+
+	case "listen":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: listen <port> [TCP|UDP]")
+		}
+		protocol := "TCP"
+		if len(args) > 2 {
+			protocol = strings.ToUpper(args[2])
+		}
+		addr, closer, err := ListenEcho(protocol, ":"+args[1])
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		fmt.Fprintf(sh.out, "echo %s on %s, press ctrl+d to stop\n", protocol, addr)
+		io.Copy(io.Discard, sh.in)
+		return nil
+
+	default:
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = sh.in
+		cmd.Stdout = sh.out
+		cmd.Stderr = sh.out
+		return cmd.Run()
+	}
+}
+
+func (sh agentShell) ls(args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	tw := tabwriter.NewWriter(sh.out, 0, 0, 1, ' ', 0)
+	for _, path := range args {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		entries := []os.FileInfo{info}
+		if info.IsDir() {
+			dirEntries, err := os.ReadDir(path)
+			if err != nil {
+				return err
+			}
+			entries = entries[:0]
+			for _, e := range dirEntries {
+				if entryInfo, err := e.Info(); err == nil {
+					entries = append(entries, entryInfo)
+				}
+			}
+		}
+		for _, e := range entries {
+			if long {
+				fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", e.Mode(), e.Size(), e.ModTime().Format("2006-01-02 15:04"), e.Name())
+			} else {
+				fmt.Fprintln(tw, e.Name())
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func (sh agentShell) ps() error {
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return err
+	}
+	pids := make([]int, 0, len(dirs))
+	for _, dir := range dirs {
+		if pid, err := strconv.Atoi(filepath.Base(dir)); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+
+	tw := tabwriter.NewWriter(sh.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tCOMMAND")
+	for _, pid := range pids {
+		cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\n", pid, strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")))
+	}
+	return tw.Flush()
+}
+
+// SplitShellWords splits a command line at whitespace and supports single and double quotes as well as backslash escapes
+func SplitShellWords(line string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if escaped {
+		return nil, fmt.Errorf("unterminated escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
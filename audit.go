@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+type AuditRecord struct {
+	// ID is the line number in the audit log. it is only part of the output of history and not stored in the record itself
+	ID              int       `json:"id,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+	User            string    `json:"user"`
+	Host            string    `json:"host"`
+	Action          string    `json:"action"`
+	Context         string    `json:"context"`
+	Namespace       string    `json:"namespace"`
+	Pod             string    `json:"pod"`
+	Image           string    `json:"image"`
+	Node            string    `json:"node"`
+	Flags           []string  `json:"flags"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	ExitCode        int       `json:"exitCode"`
+	Error           string    `json:"error,omitempty"`
+	// Run contains the resolved parameters of run sessions to replay them
+	Run *RunParameters `json:"run,omitempty"`
+}
+
+type RunParameters struct {
+	Overrides   TemplateOverrides `json:"overrides"`
+	Node        string            `json:"node,omitempty"`
+	Like        string            `json:"like,omitempty"`
+	LikeInclude []string          `json:"likeInclude,omitempty"`
+	LikeExclude []string          `json:"likeExclude,omitempty"`
+	// EphemeralNamespace, Agent and AllNodes are nil if not set, so replayed values can be turned off
+	EphemeralNamespace *bool    `json:"ephemeralNamespace,omitempty"`
+	Agent              *bool    `json:"agent,omitempty"`
+	AllNodes           *bool    `json:"allNodes,omitempty"`
+	Nodes              []string `json:"nodes,omitempty"`
+	Context            string   `json:"context,omitempty"`
+	Namespace          string   `json:"namespace,omitempty"`
+}
+
+// Merge returns the parameters with all values set in other taking precedence
+func (params RunParameters) Merge(other RunParameters) RunParameters {
+	merged := params
+	merged.Overrides = params.Overrides.Merge(other.Overrides)
+	if len(other.Node) > 0 {
+		merged.Node = other.Node
+	}
+	if len(other.Like) > 0 {
+		merged.Like = other.Like
+		merged.LikeInclude = other.LikeInclude
+		merged.LikeExclude = other.LikeExclude
+	}
+	if other.EphemeralNamespace != nil {
+		merged.EphemeralNamespace = other.EphemeralNamespace
+	}
+	if other.Agent != nil {
+		merged.Agent = other.Agent
+	}
+	if other.AllNodes != nil {
+		merged.AllNodes = other.AllNodes
+	}
+	if len(other.Nodes) > 0 {
+		merged.Nodes = other.Nodes
+	}
+	if len(other.Context) > 0 {
+		merged.Context = other.Context
+	}
+	if len(other.Namespace) > 0 {
+		merged.Namespace = other.Namespace
+	}
+	return merged
+}
+
+// FindRunParameters returns the run parameters of the record with the given id, or of the latest run for id 0
+func FindRunParameters(records []AuditRecord, id int) (RunParameters, error) {
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if id == 0 && r.Run != nil {
+			return *r.Run, nil
+		}
+		if id > 0 && r.ID == id {
+			if r.Run == nil {
+				return RunParameters{}, usageErrorf("history entry %d is not a run", id)
+			}
+			return *r.Run, nil
+		}
+	}
+	if id == 0 {
+		return RunParameters{}, usageErrorf("no previous run found in history")
+	}
+	return RunParameters{}, usageErrorf("history entry %d not found", id)
+}
+
+var (
+	// auditSession collects the audit record of the current command and is nil for commands that are not audited
+	auditSession *AuditRecord
+	// auditMutex guards auditSession for commands running multiple pods concurrently
+	auditMutex sync.Mutex
+)
+
+func getAuditLogPath() string {
+	return filepath.Join(xdg.StateHome, "testpod", "audit.jsonl")
+}
+
+func startAuditSession(action string) {
+	auditSession = &AuditRecord{
+		Timestamp: time.Now(),
+		Action:    action,
+		Flags:     os.Args[1:],
+	}
+	if u, err := user.Current(); err == nil {
+		auditSession.User = u.Username
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		auditSession.Host = hostname
+	}
+}
+
+// auditPod stores where the pod of the current session runs. must be called while the kubeconfig of the session is active
+func auditPod(podName string) {
+	if auditSession == nil {
+		return
+	}
+	// the audit log is best-effort and must not break the session
+	contextName, _ := kubectlGetCurrentContext()
+	namespace, _ := kubectlGetCurrentNamespace()
+	image, node, _ := kubectlGetPodImageAndNode(podName)
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditSession.Context = contextName
+	auditSession.Namespace = namespace
+	auditSession.Image = image
+	if len(auditSession.Pod) > 0 && auditSession.Pod != podName {
+		// sessions running the command on multiple nodes list all pods
+		auditSession.Pod += "," + podName
+		auditSession.Node += "," + node
+	} else {
+		auditSession.Pod = podName
+		auditSession.Node = node
+	}
+}
+
+func auditRunParameters(params RunParameters, nodeName string) {
+	if auditSession == nil {
+		return
+	}
+	resolved := params
+	resolved.Node = nodeName
+	if contextName, err := kubectlGetCurrentContext(); err == nil {
+		resolved.Context = contextName
+	}
+	if namespace, err := kubectlGetCurrentNamespace(); err == nil {
+		resolved.Namespace = namespace
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	auditSession.Run = &resolved
+}
+
+func auditPodDeleted(podName string, start time.Time, deleteErr error) {
+	if auditSession == nil {
+		return
+	}
+	auditMutex.Lock()
+	record := *auditSession
+	auditMutex.Unlock()
+	record.Timestamp = start
+	record.Action = "delete"
+	if record.Pod != podName {
+		// the node is unknown for single pods of multi-node sessions
+		record.Node = ""
+	}
+	record.Pod = podName
+	record.Run = nil
+	finishAuditRecord(&record, deleteErr)
+}
+
+func finishAuditSession(err error) {
+	if auditSession == nil {
+		return
+	}
+	finishAuditRecord(auditSession, err)
+}
+
+func finishAuditRecord(record *AuditRecord, err error) {
+	record.DurationSeconds = time.Since(record.Timestamp).Seconds()
+	record.ExitCode = getExitCode(err)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if err := appendAuditRecord(getAuditLogPath(), *record); err != nil {
+		fmt.Fprintln(infoOut, "WARN: failed to write audit log:", err)
+	}
+}
+
+func appendAuditRecord(path string, record AuditRecord) error {
+	record.ID = 0
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create audit log dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+// ReadAuditRecords skips corrupt lines, e.g. written partially during a crash, so the remaining history stays usable
+func ReadAuditRecords(r io.Reader) ([]AuditRecord, error) {
+	records := make([]AuditRecord, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: skip corrupt line %d of audit log: %v\n", lineNumber, err)
+			continue
+		}
+		record.ID = lineNumber
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func readAuditLog() ([]AuditRecord, error) {
+	f, err := os.Open(getAuditLogPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []AuditRecord{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return ReadAuditRecords(f)
+}
+
+type AuditFilter struct {
+	Context   string
+	Namespace string
+	// Image matches all records containing the given string in their image
+	Image string
+	Since time.Time
+	Until time.Time
+}
+
+func (filter AuditFilter) Matches(record AuditRecord) bool {
+	if len(filter.Context) > 0 && record.Context != filter.Context {
+		return false
+	}
+	if len(filter.Namespace) > 0 && record.Namespace != filter.Namespace {
+		return false
+	}
+	if len(filter.Image) > 0 && !strings.Contains(record.Image, filter.Image) {
+		return false
+	}
+	if !filter.Since.IsZero() && record.Timestamp.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !record.Timestamp.Before(filter.Until) {
+		return false
+	}
+	return true
+}
+
+// ParseAuditDate accepts dates like 2006-01-02 in local time or RFC3339 timestamps. dates are moved to the end of the day for untilDate, so the whole day is included
+func ParseAuditDate(str string, untilDate bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, str); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", str, time.Local)
+	if err != nil {
+		return time.Time{}, usageErrorf("date must be like 2006-01-02 or 2006-01-02T15:04:05Z07:00, got %q instead", str)
+	}
+	if untilDate {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func WriteAuditRecords(w io.Writer, records []AuditRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tUSER\tACTION\tCONTEXT\tNAMESPACE\tPOD\tIMAGE\tNODE\tDURATION\tEXIT")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", r.ID, r.Timestamp.Local().Format("2006-01-02 15:04:05"),
+			orDash(r.User), r.Action, orDash(r.Context), orDash(r.Namespace), orDash(r.Pod), orDash(r.Image), orDash(r.Node),
+			FormatDuration(time.Duration(r.DurationSeconds*float64(time.Second))), r.ExitCode)
+	}
+	return tw.Flush()
+}
+
+func orDash(str string) string {
+	if len(str) == 0 {
+		return "-"
+	}
+	return str
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testpod", "audit.jsonl")
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, appendAuditRecord(path, AuditRecord{Timestamp: start, User: "jdoe", Action: "run", Context: "dev", Namespace: "default", Pod: "testpod-a", Image: "alpine", Node: "node-1", Flags: []string{"run", "--node", "node-1"}, DurationSeconds: 90, ExitCode: 0}))
+	require.NoError(t, appendAuditRecord(path, AuditRecord{Timestamp: start.Add(time.Hour), User: "jdoe", Action: "enter", Context: "prod", Namespace: "payments", Pod: "testpod-b", Image: "nicolaka/netshoot:latest", DurationSeconds: 5, ExitCode: 1, Error: "exec into Pod: exit status 1"}))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	records, err := ReadAuditRecords(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, 1, records[0].ID)
+	require.Equal(t, 2, records[1].ID)
+	require.Equal(t, []string{"run", "--node", "node-1"}, records[0].Flags)
+	require.True(t, start.Equal(records[0].Timestamp))
+
+	require.True(t, AuditFilter{}.Matches(records[0]))
+	require.True(t, AuditFilter{Context: "prod", Namespace: "payments"}.Matches(records[1]))
+	require.False(t, AuditFilter{Context: "prod"}.Matches(records[0]))
+	require.True(t, AuditFilter{Image: "netshoot"}.Matches(records[1]))
+	require.False(t, AuditFilter{Image: "netshoot"}.Matches(records[0]))
+	require.True(t, AuditFilter{Since: start.Add(time.Minute)}.Matches(records[1]))
+	require.False(t, AuditFilter{Since: start.Add(time.Minute)}.Matches(records[0]))
+	require.False(t, AuditFilter{Until: start.Add(time.Hour)}.Matches(records[1]))
+
+	// records keep their line number as id when corrupt lines are skipped
+	records, err = ReadAuditRecords(strings.NewReader("{\"action\":\"run\"}\nno json\n{\"action\":\"enter\"}\n{\"action\":\"del"))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, 1, records[0].ID)
+	require.Equal(t, 3, records[1].ID)
+	require.Equal(t, "enter", records[1].Action)
+
+	data, err = json.Marshal(records[1])
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"id":3`)
+	require.NoError(t, appendAuditRecord(path, records[1]))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), `"id"`)
+}
+
+func TestParseAuditDate(t *testing.T) {
+	since, err := ParseAuditDate("2026-10-18", false)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local), since)
+
+	until, err := ParseAuditDate("2026-10-18", true)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local), until)
+
+	ts, err := ParseAuditDate("2026-10-18T12:30:00Z", true)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC), ts)
+
+	_, err = ParseAuditDate("yesterday", false)
+	require.Error(t, err)
+}
+
+func TestFindRunParameters(t *testing.T) {
+	records := []AuditRecord{
+		{ID: 1, Action: "run", Run: &RunParameters{Node: "node-1", Context: "dev"}},
+		{ID: 2, Action: "enter"},
+		{ID: 3, Action: "run", Run: &RunParameters{Node: "node-2", Context: "prod", Namespace: "payments"}},
+		{ID: 4, Action: "delete"},
+	}
+
+	params, err := FindRunParameters(records, 0)
+	require.NoError(t, err)
+	require.Equal(t, "node-2", params.Node)
+	params, err = FindRunParameters(records, 1)
+	require.NoError(t, err)
+	require.Equal(t, "node-1", params.Node)
+	_, err = FindRunParameters(records, 2)
+	require.Error(t, err)
+	_, err = FindRunParameters(records, 5)
+	require.Error(t, err)
+	_, err = FindRunParameters(nil, 0)
+	require.Error(t, err)
+
+	merged := RunParameters{Node: "node-2", Like: "deployment/foo", LikeExclude: []string{"env"}, Context: "prod", Namespace: "payments"}.Merge(RunParameters{Context: "staging", Like: "deployment/bar"})
+	require.Equal(t, RunParameters{
+		Overrides: TemplateOverrides{Resources: ResourcesTemplate{Requests: map[string]string{}, Limits: map[string]string{}}},
+		Node:      "node-2",
+		Like:      "deployment/bar",
+		Context:   "staging",
+		Namespace: "payments",
+	}, merged)
+
+	// replayed flags stay set unless they are explicitly turned off
+	stored := RunParameters{Agent: ptr(true), AllNodes: ptr(true), EphemeralNamespace: ptr(true)}
+	merged = stored.Merge(RunParameters{})
+	require.True(t, isTrue(merged.Agent))
+	require.True(t, isTrue(merged.AllNodes))
+	require.True(t, isTrue(merged.EphemeralNamespace))
+	merged = stored.Merge(RunParameters{Agent: ptr(false), EphemeralNamespace: ptr(false)})
+	require.False(t, isTrue(merged.Agent))
+	require.True(t, isTrue(merged.AllNodes))
+	require.False(t, isTrue(merged.EphemeralNamespace))
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const BenchLabel = "testpod.io/bench"
+
+// modes of the bench protocol. every tcp connection and udp datagram starts with the mode
+const (
+	benchModeEcho   = 'e'
+	benchModeSink   = 's'
+	benchModeReport = 'r'
+)
+
+type BenchOptions struct {
+	Protocol string
+	Duration time.Duration
+	// Samples is the number of round trips measured for RTT and jitter
+	Samples     int
+	UDPRateMbps int
+	// ConnectOnly measures the RTT with tcp handshakes, as targets like Services do not run a bench server
+	ConnectOnly bool
+}
+
+type BenchResult struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Protocol string `json:"protocol"`
+	// ThroughputMbps is the throughput received by the server in Mbit/s, zero if not measured
+	ThroughputMbps float64 `json:"throughputMbps"`
+	// IntervalThroughputMbps are the percentiles of the throughput sent in 100ms intervals
+	IntervalThroughputMbps *Percentiles `json:"intervalThroughputMbps,omitempty"`
+	RTTMillis              *Percentiles `json:"rttMillis,omitempty"`
+	JitterMillis           float64      `json:"jitterMillis"`
+	// LossPercent is the udp packet loss of the throughput test
+	LossPercent float64 `json:"lossPercent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+type Percentiles struct {
+	Min float64 `json:"min"`
+	P10 float64 `json:"p10"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// ComputePercentiles uses the nearest-rank method and returns nil without samples
+func ComputePercentiles(samples []float64) *Percentiles {
+	if len(samples) == 0 {
+		return nil
+	}
+	sorted := append([]float64{}, samples...)
+	sort.Float64s(sorted)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return &Percentiles{
+		Min: sorted[0],
+		P10: rank(10),
+		P50: rank(50),
+		P90: rank(90),
+		P99: rank(99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// ComputeJitter is the mean difference between consecutive round trip times like in RFC 3550
+func ComputeJitter(samples []float64) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+	sum := 0.0
+	for i := 1; i < len(samples); i++ {
+		sum += math.Abs(samples[i] - samples[i-1])
+	}
+	return sum / float64(len(samples)-1)
+}
+
+// ListenBench serves the bench protocol on the tcp and udp port of the address until the returned closer is closed
+func ListenBench(address string) (net.Addr, io.Closer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	host, _, _ := net.SplitHostPort(address)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	packetConn, err := net.ListenPacket("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveBenchConn(conn)
+		}
+	}()
+	go serveBenchPackets(packetConn)
+	return listener.Addr(), multiCloser{listener, packetConn}, nil
+}
+
+type multiCloser []io.Closer
+
+func (closers multiCloser) Close() error {
+	var firstErr error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func serveBenchConn(conn net.Conn) {
+	defer conn.Close()
+	mode := make([]byte, 1)
+	if _, err := io.ReadFull(conn, mode); err != nil {
+		return
+	}
+	switch mode[0] {
+	case benchModeEcho:
+		io.Copy(conn, conn)
+	case benchModeSink:
+		n, _ := io.Copy(io.Discard, conn)
+		binary.Write(conn, binary.BigEndian, uint64(n))
+	}
+}
+
+// serveBenchPackets echoes datagrams or counts them per session, which is identified by the 8 bytes after the mode
+func serveBenchPackets(conn net.PacketConn) {
+	var mutex sync.Mutex
+	received := make(map[uint64]uint64)
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 9 {
+			continue
+		}
+		session := binary.BigEndian.Uint64(buf[1:9])
+		switch buf[0] {
+		case benchModeEcho:
+			conn.WriteTo(buf[:n], addr)
+		case benchModeSink:
+			mutex.Lock()
+			received[session] += uint64(n)
+			mutex.Unlock()
+		case benchModeReport:
+			mutex.Lock()
+			count := received[session]
+			mutex.Unlock()
+			reply := make([]byte, 17)
+			copy(reply, buf[:9])
+			binary.BigEndian.PutUint64(reply[9:], count)
+			conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+// RunBench is run by the agent of the sending pod
+func RunBench(address string, opts BenchOptions) BenchResult {
+	result := BenchResult{To: address, Protocol: opts.Protocol}
+	var err error
+	switch {
+	case opts.ConnectOnly:
+		err = benchConnect(&result, address, opts)
+	case opts.Protocol == "UDP":
+		err = benchUDP(&result, address, opts)
+	default:
+		err = benchTCP(&result, address, opts)
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func benchConnect(result *BenchResult, address string, opts BenchOptions) error {
+	rtts := make([]float64, 0, opts.Samples)
+	for i := 0; i < opts.Samples; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		rtts = append(rtts, millisSince(start))
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	result.RTTMillis = ComputePercentiles(rtts)
+	result.JitterMillis = ComputeJitter(rtts)
+	return nil
+}
+
+func benchTCP(result *BenchResult, address string, opts BenchOptions) error {
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(time.Duration(opts.Samples)*time.Second + 5*time.Second)); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte{benchModeEcho}); err != nil {
+		return err
+	}
+	rtts := make([]float64, 0, opts.Samples)
+	ping := make([]byte, 8)
+	for i := 0; i < opts.Samples; i++ {
+		start := time.Now()
+		if _, err := conn.Write(ping); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, ping); err != nil {
+			return err
+		}
+		rtts = append(rtts, millisSince(start))
+		time.Sleep(10 * time.Millisecond)
+	}
+	result.RTTMillis = ComputePercentiles(rtts)
+	result.JitterMillis = ComputeJitter(rtts)
+
+	sinkConn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer sinkConn.Close()
+	if err := sinkConn.SetDeadline(time.Now().Add(opts.Duration + 10*time.Second)); err != nil {
+		return err
+	}
+	if _, err := sinkConn.Write([]byte{benchModeSink}); err != nil {
+		return err
+	}
+	chunk := make([]byte, 128*1024)
+	intervals := make([]float64, 0)
+	start := time.Now()
+	intervalStart := start
+	intervalBytes := 0
+	for time.Since(start) < opts.Duration {
+		n, err := sinkConn.Write(chunk)
+		if err != nil {
+			return err
+		}
+		intervalBytes += n
+		if elapsed := time.Since(intervalStart); elapsed >= 100*time.Millisecond {
+			intervals = append(intervals, float64(intervalBytes)*8/elapsed.Seconds()/1e6)
+			intervalStart = time.Now()
+			intervalBytes = 0
+		}
+	}
+	if err := sinkConn.(*net.TCPConn).CloseWrite(); err != nil {
+		return err
+	}
+	var received uint64
+	if err := binary.Read(sinkConn, binary.BigEndian, &received); err != nil {
+		return fmt.Errorf("read received bytes: %w", err)
+	}
+	result.ThroughputMbps = float64(received) * 8 / time.Since(start).Seconds() / 1e6
+	result.IntervalThroughputMbps = ComputePercentiles(intervals)
+	return nil
+}
+
+func benchUDP(result *BenchResult, address string, opts BenchOptions) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	sessionBytes := make([]byte, 8)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		return err
+	}
+
+	// lost pings are not counted, the loss is measured by the throughput test
+	rtts := make([]float64, 0, opts.Samples)
+	ping := append([]byte{benchModeEcho}, sessionBytes...)
+	ping = binary.BigEndian.AppendUint64(ping, 0)
+	reply := make([]byte, 64*1024)
+	for i := 0; i < opts.Samples; i++ {
+		binary.BigEndian.PutUint64(ping[9:], uint64(i))
+		start := time.Now()
+		if _, err := conn.Write(ping); err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+		for {
+			n, err := conn.Read(reply)
+			if err != nil {
+				break
+			}
+			// skip late replies of previous pings
+			if n == len(ping) && binary.BigEndian.Uint64(reply[9:17]) == uint64(i) {
+				rtts = append(rtts, millisSince(start))
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(rtts) == 0 {
+		return fmt.Errorf("no udp echo received")
+	}
+	result.RTTMillis = ComputePercentiles(rtts)
+	result.JitterMillis = ComputeJitter(rtts)
+
+	// send at a fixed rate, as udp has no congestion control and would only measure the loss otherwise
+	datagram := make([]byte, 1400)
+	datagram[0] = benchModeSink
+	copy(datagram[1:], sessionBytes)
+	bytesPerMilli := float64(opts.UDPRateMbps) * 1e6 / 8 / 1000
+	var sent uint64
+	start := time.Now()
+	for time.Since(start) < opts.Duration {
+		if float64(sent) > bytesPerMilli*float64(time.Since(start).Milliseconds()+1) {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		n, err := conn.Write(datagram)
+		if err != nil {
+			return err
+		}
+		sent += uint64(n)
+	}
+	elapsed := time.Since(start)
+	time.Sleep(200 * time.Millisecond)
+
+	report := append([]byte{benchModeReport}, sessionBytes...)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Write(report); err != nil {
+			return err
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return err
+		}
+		n, err := conn.Read(reply)
+		if err != nil || n != 17 || reply[0] != benchModeReport {
+			continue
+		}
+		received := binary.BigEndian.Uint64(reply[9:17])
+		result.ThroughputMbps = float64(received) * 8 / elapsed.Seconds() / 1e6
+		if sent > 0 && received <= sent {
+			result.LossPercent = 100 * float64(sent-received) / float64(sent)
+		}
+		return nil
+	}
+	return fmt.Errorf("no udp report received")
+}
+
+func WriteBenchResults(w io.Writer, results []BenchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FROM\tTO\tPROTOCOL\tTHROUGHPUT\tINTERVAL P10/P50/P90\tRTT MIN/P50/P90/P99/MAX\tJITTER\tLOSS\tERROR")
+	for _, r := range results {
+		throughput, intervals, rtt, jitter, loss := "-", "-", "-", "-", "-"
+		if r.ThroughputMbps > 0 {
+			throughput = fmt.Sprintf("%.1f Mbit/s", r.ThroughputMbps)
+		}
+		if p := r.IntervalThroughputMbps; p != nil {
+			intervals = fmt.Sprintf("%.0f/%.0f/%.0f Mbit/s", p.P10, p.P50, p.P90)
+		}
+		if p := r.RTTMillis; p != nil {
+			rtt = fmt.Sprintf("%s/%s/%s/%s/%s ms", formatMillis(p.Min), formatMillis(p.P50), formatMillis(p.P90), formatMillis(p.P99), formatMillis(p.Max))
+			jitter = formatMillis(r.JitterMillis) + " ms"
+		}
+		if r.Protocol == "UDP" && r.ThroughputMbps > 0 {
+			loss = strconv.FormatFloat(r.LossPercent, 'f', 2, 64) + "%"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.From, r.To, r.Protocol, throughput, intervals, rtt, jitter, loss, orDash(r.Error))
+	}
+	return tw.Flush()
+}
+
+func formatMillis(millis float64) string {
+	if millis < 10 {
+		return strconv.FormatFloat(millis, 'f', 2, 64)
+	}
+	return strconv.FormatFloat(millis, 'f', 1, 64)
+}
+
+func WriteBenchResultsJSON(w io.Writer, results []BenchResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// ParseBenchProtocols accepts TCP and UDP in any case
+func ParseBenchProtocols(protocols []string) ([]string, error) {
+	parsed := make([]string, 0, len(protocols))
+	for _, p := range protocols {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if p != "TCP" && p != "UDP" {
+			return nil, usageErrorf("protocol must be TCP or UDP, got %q instead", p)
+		}
+		parsed = append(parsed, p)
+	}
+	if len(parsed) == 0 {
+		return nil, usageErrorf("at least one protocol is required")
+	}
+	return parsed, nil
+}
+
+// RunBenchInPod runs the agent bench command in the sending testpod
+func RunBenchInPod(podName string, command []string) (BenchResult, error) {
+	out, err := kubectlExecGetStdout(podName, command...)
+	if err != nil {
+		return BenchResult{}, fmt.Errorf("run benchmark: %w", err)
+	}
+	var result BenchResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return BenchResult{}, fmt.Errorf("parse benchmark result: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestComputePercentiles(t *testing.T) {
+	require.Nil(t, ComputePercentiles(nil))
+
+	samples := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, float64(i))
+	}
+	require.Equal(t, &Percentiles{Min: 1, P10: 10, P50: 50, P90: 90, P99: 99, Max: 100}, ComputePercentiles(samples))
+	require.Equal(t, float64(100), samples[0], "samples must not be sorted in place")
+
+	require.Equal(t, &Percentiles{Min: 7, P10: 7, P50: 7, P90: 7, P99: 7, Max: 7}, ComputePercentiles([]float64{7}))
+}
+
+func TestComputeJitter(t *testing.T) {
+	require.Equal(t, 0.0, ComputeJitter([]float64{1}))
+	require.InDelta(t, 1.5, ComputeJitter([]float64{1, 2, 4, 3, 1}), 1e-9)
+}
+
+func TestRunBench(t *testing.T) {
+	addr, closer, err := ListenBench("127.0.0.1:0")
+	require.NoError(t, err)
+	defer closer.Close()
+
+	for _, protocol := range []string{"TCP", "UDP"} {
+		result := RunBench(addr.String(), BenchOptions{Protocol: protocol, Duration: 200 * time.Millisecond, Samples: 5, UDPRateMbps: 10})
+		require.Empty(t, result.Error, protocol)
+		require.Equal(t, protocol, result.Protocol)
+		require.Greater(t, result.ThroughputMbps, 0.0, protocol)
+		require.NotNil(t, result.RTTMillis, protocol)
+	}
+
+	result := RunBench(addr.String(), BenchOptions{Protocol: "TCP", Samples: 3, ConnectOnly: true})
+	require.Empty(t, result.Error)
+	require.Zero(t, result.ThroughputMbps)
+	require.NotNil(t, result.RTTMillis)
+}
+
+func TestWriteBenchResults(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteBenchResults(&buf, []BenchResult{
+		{From: "worker-1", To: "worker-2", Protocol: "UDP", ThroughputMbps: 99.5, RTTMillis: &Percentiles{Min: 0.1, P50: 0.2, P90: 0.3, P99: 0.4, Max: 12.5}, JitterMillis: 0.05, LossPercent: 0.5},
+	}))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, []string{"worker-1", "worker-2", "UDP", "99.5", "Mbit/s", "-", "0.10/0.20/0.30/0.40/12.5", "ms", "0.05", "ms", "0.50%", "-"}, strings.Fields(lines[1]))
+}
+
+func TestParseBenchProtocols(t *testing.T) {
+	protocols, err := ParseBenchProtocols([]string{"tcp", " UDP"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"TCP", "UDP"}, protocols)
+	_, err = ParseBenchProtocols([]string{"SCTP"})
+	require.Error(t, err)
+}
+
+func TestRunBenchInPod(t *testing.T) {
+	// fake kubectl printing the notice of kubectl exec for pods with multiple containers
+	fakeKubectl(t, "echo 'Defaulted container \"main\" out of: main, testpod-agent (init)' >&2\n"+
+		"echo '{\"protocol\":\"TCP\",\"throughputMbps\":940.5}'\n")
+
+	result, err := RunBenchInPod("testpod-dev", []string{AgentPath, "agent", "bench", "10.0.0.2:7778"})
+	require.NoError(t, err)
+	require.Equal(t, "TCP", result.Protocol)
+	require.Equal(t, 940.5, result.ThroughputMbps)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultCaptureImage = "nicolaka/netshoot"
+
+// ParseCaptureTarget accepts pod names, pod/name and node/name
+func ParseCaptureTarget(str string) (kind, name string, err error) {
+	kind, name, found := strings.Cut(str, "/")
+	if !found {
+		kind, name = "pod", str
+	}
+	switch kind {
+	case "pod", "po":
+		kind = "pod"
+	case "node", "no":
+		kind = "node"
+	default:
+		return "", "", usageErrorf("target must be like pod-name, pod/name or node/name, got %q instead", str)
+	}
+	if len(name) == 0 {
+		return "", "", usageErrorf("target must be like pod-name, pod/name or node/name, got %q instead", str)
+	}
+	return kind, name, nil
+}
+
+// MakeTcpdumpCommand writes the pcap to stdout and flushes every packet, so the capture can be followed live
+func MakeTcpdumpCommand(iface, filter string, count int) []string {
+	command := []string{"tcpdump", "-U", "-n", "-i", iface, "-w", "-"}
+	if count > 0 {
+		command = append(command, "-c", strconv.Itoa(count))
+	}
+	if len(strings.TrimSpace(filter)) > 0 {
+		command = append(command, filter)
+	}
+	return command
+}
+
+// captureSleepPIDFile lets the sleep of the ephemeral container be stopped without knowing whether it shares the process namespace of the pod
+const captureSleepPIDFile = "/tmp/testpod-capture.pid"
+
+// MakeCaptureSleepCommand keeps the ephemeral container running for the tcpdump exec. ephemeral containers cannot be removed, so it also ends after maxDuration
+func MakeCaptureSleepCommand(maxDuration time.Duration) []string {
+	return []string{"sh", "-c", fmt.Sprintf("echo $$ > %s; trap 'exit 0' TERM INT; sleep %d & wait", captureSleepPIDFile, int(maxDuration.Seconds()))}
+}
+
+func MakeCaptureStopCommand() []string {
+	return []string{"sh", "-c", fmt.Sprintf("kill $(cat %s)", captureSleepPIDFile)}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCaptureTarget(t *testing.T) {
+	tests := []struct {
+		str  string
+		kind string
+		name string
+	}{
+		{"web-0", "pod", "web-0"},
+		{"pod/web-0", "pod", "web-0"},
+		{"po/web-0", "pod", "web-0"},
+		{"node/worker-1", "node", "worker-1"},
+		{"no/worker-1", "node", "worker-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.str, func(t *testing.T) {
+			kind, name, err := ParseCaptureTarget(tt.str)
+			require.NoError(t, err)
+			require.Equal(t, tt.kind, kind)
+			require.Equal(t, tt.name, name)
+		})
+	}
+
+	for _, str := range []string{"svc/web", "node/", ""} {
+		_, _, err := ParseCaptureTarget(str)
+		require.Error(t, err, str)
+		require.Equal(t, ExitCodeUsageError, getExitCode(err))
+	}
+}
+
+func TestMakeTcpdumpCommand(t *testing.T) {
+	require.Equal(t, []string{"tcpdump", "-U", "-n", "-i", "any", "-w", "-"}, MakeTcpdumpCommand("any", "", 0))
+	require.Equal(t, []string{"tcpdump", "-U", "-n", "-i", "eth0", "-w", "-", "-c", "10", "tcp port 443"}, MakeTcpdumpCommand("eth0", "tcp port 443", 10))
+	require.Equal(t, []string{"tcpdump", "-U", "-n", "-i", "any", "-w", "-"}, MakeTcpdumpCommand("any", "  ", 0))
+}
+
+func TestMakeCaptureSleepCommand(t *testing.T) {
+	command := MakeCaptureSleepCommand(time.Hour)
+	require.Equal(t, []string{"sh", "-c"}, command[:2])
+	require.Contains(t, command[2], "sleep 3600 & wait")
+	require.Contains(t, command[2], captureSleepPIDFile)
+	require.Contains(t, MakeCaptureStopCommand()[2], captureSleepPIDFile)
+}
@@ -5,39 +5,114 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/adrg/xdg"
 )
 
 type Template struct {
-	DefaultImage  string
-	DefaultShell  string
+	DefaultImage string
+	DefaultShell string
+	// AgentInitImage needs to provide sh, cat and chmod to receive the agent binary
+	AgentInitImage string
+	// CaptureImage needs to provide tcpdump and is used instead of DefaultImage by capture
+	CaptureImage  string
 	Pod           PodTemplate
 	NetworkPolicy NetworkPolicyTemplate
+	// Guardrails are only read from the default template, so they apply regardless of the selected template
+	Guardrails []GuardrailTemplate
+}
+
+type GuardrailTemplate struct {
+	// Context and Namespace are glob patterns, empty patterns match everything
+	Context          string
+	Namespace        string
+	ForbiddenOptions []string
 }
 
 type PodTemplate struct {
-	AdditionalLabels map[string]string
-	Command          []string
-	Args             []string
+	AdditionalLabels             map[string]string
+	Command                      []string
+	Args                         []string
+	Resources                    ResourcesTemplate
+	Security                     string
+	Capabilities                 []string
+	HostNetwork                  bool
+	HostPID                      bool
+	HostIPC                      bool
+	Privileged                   bool
+	Tolerations                  []TolerationTemplate
+	ServiceAccountName           string
+	AutomountServiceAccountToken *bool
+	// AgentBinary is the local path of the agent binary to inject, set by commands running the agent in the pod
+	AgentBinary string `json:"-"`
+}
+
+type TolerationTemplate struct {
+	Key      string
+	Operator string
+	Value    string
+	Effect   string
+}
+
+type ResourcesTemplate struct {
+	Requests map[string]string
+	Limits   map[string]string
 }
 
 type NetworkPolicyTemplate struct {
+	// CreateAllowAll is kept for backwards compatibility and equals the allow-all-tcp preset
 	CreateAllowAll bool
+	Presets        []string
+	Egress         []NetworkPolicyRuleTemplate
+	Ingress        []NetworkPolicyRuleTemplate
+}
+
+type NetworkPolicyRuleTemplate struct {
+	Ports             []NetworkPolicyPortTemplate
+	CIDRs             []string
+	NamespaceSelector map[string]string
+	PodSelector       map[string]string
+}
+
+type NetworkPolicyPortTemplate struct {
+	Protocol string
+	Port     int
+	EndPort  int
 }
 
 func NewDefaultTemplate() Template {
 	return Template{
-		DefaultImage: "alpine",
-		DefaultShell: "/bin/sh",
+		DefaultImage:   "alpine",
+		DefaultShell:   "/bin/sh",
+		AgentInitImage: DefaultAgentInitImage,
+		CaptureImage:   DefaultCaptureImage,
 		Pod: PodTemplate{
 			AdditionalLabels: map[string]string{},
 			Command:          []string{"sleep"},
 			Args:             []string{"infinity"},
+			Resources: ResourcesTemplate{
+				Requests: map[string]string{},
+				Limits:   map[string]string{},
+			},
+			Security:                     "",
+			Capabilities:                 []string{},
+			HostNetwork:                  false,
+			HostPID:                      false,
+			HostIPC:                      false,
+			Privileged:                   false,
+			Tolerations:                  []TolerationTemplate{},
+			ServiceAccountName:           "",
+			AutomountServiceAccountToken: nil,
 		},
 		NetworkPolicy: NetworkPolicyTemplate{
 			CreateAllowAll: false,
+			Presets:        []string{},
+			Egress:         []NetworkPolicyRuleTemplate{},
+			Ingress:        []NetworkPolicyRuleTemplate{},
 		},
+		Guardrails: []GuardrailTemplate{},
 	}
 }
 
@@ -65,23 +140,66 @@ func ReadTemplate() (Template, error) {
 		}
 		return Template{}, fmt.Errorf("read default template file: %w", err)
 	}
+	return unmarshalTemplate(data)
+}
 
+// unmarshalTemplate initializes maps missing in the file, so commands can add labels without checks
+func unmarshalTemplate(data []byte) (Template, error) {
 	var tpl Template
 	if err := json.Unmarshal(data, &tpl); err != nil {
 		return Template{}, fmt.Errorf("unmarshal file content as json: %w", err)
 	}
-
+	if tpl.Pod.AdditionalLabels == nil {
+		tpl.Pod.AdditionalLabels = make(map[string]string)
+	}
 	return tpl, nil
 }
 
 type TemplateOverrides struct {
+	// Template is the name of the template file in the config dir without extension. empty means default
+	Template            string
 	Image               string
 	Shell               string
 	AdditionalPodLabels map[string]string
+	Resources           ResourcesTemplate
+	Security            string
+	Capabilities        []string
+	// HostNetwork, HostPID and HostIPC are nil if not set, so replayed values can be turned off
+	HostNetwork                  *bool
+	HostPID                      *bool
+	HostIPC                      *bool
+	ServiceAccountName           string
+	AutomountServiceAccountToken *bool
 }
 
-func ReadTemplateWithOverrides(overrides TemplateOverrides) (Template, error) {
+func ReadNamedTemplate(name string) (Template, error) {
+	if len(name) == 0 || name == "default" {
+		return ReadTemplate()
+	}
+	if !templateNamePattern.MatchString(name) {
+		return Template{}, usageErrorf("invalid template name %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(xdg.ConfigHome, "testpod", name+".json"))
+	if err != nil {
+		return Template{}, fmt.Errorf("read template file: %w", err)
+	}
+	return unmarshalTemplate(data)
+}
+
+// ReadGuardrails returns the guardrails of the default template
+func ReadGuardrails() ([]GuardrailTemplate, error) {
 	tpl, err := ReadTemplate()
+	if err != nil {
+		return nil, err
+	}
+	return tpl.Guardrails, nil
+}
+
+var templateNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-.]+$`)
+
+func ReadTemplateWithOverrides(overrides TemplateOverrides) (Template, error) {
+	tpl, err := ReadNamedTemplate(overrides.Template)
 	if err != nil {
 		return Template{}, err
 	}
@@ -97,6 +215,125 @@ func ReadTemplateWithOverrides(overrides TemplateOverrides) (Template, error) {
 			tpl.Pod.AdditionalLabels[k] = v
 		}
 	}
+	if len(overrides.Security) > 0 {
+		tpl.Pod.Security = overrides.Security
+	}
+	tpl.Pod.Capabilities = append(tpl.Pod.Capabilities, overrides.Capabilities...)
+	if overrides.HostNetwork != nil {
+		tpl.Pod.HostNetwork = *overrides.HostNetwork
+	}
+	if overrides.HostPID != nil {
+		tpl.Pod.HostPID = *overrides.HostPID
+	}
+	if overrides.HostIPC != nil {
+		tpl.Pod.HostIPC = *overrides.HostIPC
+	}
+	if len(overrides.ServiceAccountName) > 0 {
+		tpl.Pod.ServiceAccountName = overrides.ServiceAccountName
+	}
+	if overrides.AutomountServiceAccountToken != nil {
+		tpl.Pod.AutomountServiceAccountToken = overrides.AutomountServiceAccountToken
+	}
+	for k, v := range overrides.Resources.Requests {
+		if tpl.Pod.Resources.Requests == nil {
+			tpl.Pod.Resources.Requests = make(map[string]string)
+		}
+		tpl.Pod.Resources.Requests[k] = v
+	}
+	for k, v := range overrides.Resources.Limits {
+		if tpl.Pod.Resources.Limits == nil {
+			tpl.Pod.Resources.Limits = make(map[string]string)
+		}
+		tpl.Pod.Resources.Limits[k] = v
+	}
 
 	return tpl, nil
 }
+
+// Merge returns the overrides with all values set in other taking precedence
+func (overrides TemplateOverrides) Merge(other TemplateOverrides) TemplateOverrides {
+	merged := overrides
+	if len(other.Template) > 0 {
+		merged.Template = other.Template
+	}
+	if len(other.Image) > 0 {
+		merged.Image = other.Image
+	}
+	if len(other.Shell) > 0 {
+		merged.Shell = other.Shell
+	}
+	if len(other.AdditionalPodLabels) > 0 {
+		merged.AdditionalPodLabels = make(map[string]string)
+		for k, v := range overrides.AdditionalPodLabels {
+			merged.AdditionalPodLabels[k] = v
+		}
+		for k, v := range other.AdditionalPodLabels {
+			merged.AdditionalPodLabels[k] = v
+		}
+	}
+	merged.Resources = ResourcesTemplate{Requests: make(map[string]string), Limits: make(map[string]string)}
+	for _, res := range []ResourcesTemplate{overrides.Resources, other.Resources} {
+		for k, v := range res.Requests {
+			merged.Resources.Requests[k] = v
+		}
+		for k, v := range res.Limits {
+			merged.Resources.Limits[k] = v
+		}
+	}
+	if len(other.Security) > 0 {
+		merged.Security = other.Security
+	}
+	if len(other.Capabilities) > 0 {
+		merged.Capabilities = other.Capabilities
+	}
+	if other.HostNetwork != nil {
+		merged.HostNetwork = other.HostNetwork
+	}
+	if other.HostPID != nil {
+		merged.HostPID = other.HostPID
+	}
+	if other.HostIPC != nil {
+		merged.HostIPC = other.HostIPC
+	}
+	if len(other.ServiceAccountName) > 0 {
+		merged.ServiceAccountName = other.ServiceAccountName
+	}
+	if other.AutomountServiceAccountToken != nil {
+		merged.AutomountServiceAccountToken = other.AutomountServiceAccountToken
+	}
+	return merged
+}
+
+var quantityPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|Ki|M|Mi|G|Gi|T|Ti|P|Pi|E|Ei)?$`)
+
+func (res *ResourcesTemplate) SetResourceFromFlag(resourceName, value string) error {
+	// value is either "request" or "request:limit"
+	request, limit, hasLimit := strings.Cut(value, ":")
+	if !hasLimit {
+		limit = request
+	}
+	if !quantityPattern.MatchString(request) {
+		return usageErrorf("invalid %s request %q", resourceName, request)
+	}
+	if !quantityPattern.MatchString(limit) {
+		return usageErrorf("invalid %s limit %q", resourceName, limit)
+	}
+
+	if res.Requests == nil {
+		res.Requests = make(map[string]string)
+	}
+	if res.Limits == nil {
+		res.Limits = make(map[string]string)
+	}
+	res.Requests[resourceName] = request
+	res.Limits[resourceName] = limit
+	return nil
+}
+
+func (nwPol NetworkPolicyTemplate) Enabled() bool {
+	return nwPol.CreateAllowAll || len(nwPol.Presets) > 0 || len(nwPol.Egress) > 0 || len(nwPol.Ingress) > 0
+}
+
+func (pod PodTemplate) UsesHostNamespaces() bool {
+	return pod.HostNetwork || pod.HostPID || pod.HostIPC
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adrg/xdg"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestConfig lets the config dir point to a temp dir containing the given template files
+func writeTestConfig(t *testing.T, files map[string]string) {
+	configHome := xdg.ConfigHome
+	xdg.ConfigHome = t.TempDir()
+	t.Cleanup(func() { xdg.ConfigHome = configHome })
+	require.NoError(t, os.MkdirAll(filepath.Join(xdg.ConfigHome, "testpod"), 0700))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(xdg.ConfigHome, "testpod", name), []byte(content), 0600))
+	}
+}
+
+func TestReadTemplateWithoutLabels(t *testing.T) {
+	writeTestConfig(t, map[string]string{
+		"default.json": `{"DefaultImage": "alpine", "Pod": {"AdditionalLabels": null}}`,
+		"scratch.json": `{"DefaultImage": "busybox"}`,
+	})
+
+	for _, name := range []string{"", "scratch"} {
+		tpl, err := ReadNamedTemplate(name)
+		require.NoError(t, err)
+		require.NotNil(t, tpl.Pod.AdditionalLabels)
+	}
+}
+
+func TestSetResourceFromFlag(t *testing.T) {
+	var res ResourcesTemplate
+	require.NoError(t, res.SetResourceFromFlag("cpu", "100m"))
+	require.NoError(t, res.SetResourceFromFlag("memory", "128Mi:1Gi"))
+	require.Equal(t, map[string]string{"cpu": "100m", "memory": "128Mi"}, res.Requests)
+	require.Equal(t, map[string]string{"cpu": "100m", "memory": "1Gi"}, res.Limits)
+
+	require.Error(t, res.SetResourceFromFlag("cpu", "lots"))
+	require.Error(t, res.SetResourceFromFlag("memory", "1Gi:"))
+}
+
+func TestMergeTemplateOverrides(t *testing.T) {
+	automount := false
+	stored := TemplateOverrides{
+		Template:            "netdebug",
+		Image:               "alpine",
+		AdditionalPodLabels: map[string]string{"team": "a", "purpose": "debug"},
+		Resources:           ResourcesTemplate{Requests: map[string]string{"cpu": "100m"}, Limits: map[string]string{"cpu": "100m"}},
+		Capabilities:        []string{"NET_ADMIN"},
+		HostNetwork:         ptr(true),
+		HostPID:             ptr(true),
+	}
+	merged := stored.Merge(TemplateOverrides{
+		Image:                        "nicolaka/netshoot",
+		AdditionalPodLabels:          map[string]string{"team": "b"},
+		Resources:                    ResourcesTemplate{Requests: map[string]string{"memory": "128Mi"}, Limits: map[string]string{"memory": "1Gi"}},
+		AutomountServiceAccountToken: &automount,
+		HostPID:                      ptr(false),
+	})
+	require.Equal(t, TemplateOverrides{
+		Template:                     "netdebug",
+		Image:                        "nicolaka/netshoot",
+		AdditionalPodLabels:          map[string]string{"team": "b", "purpose": "debug"},
+		Resources:                    ResourcesTemplate{Requests: map[string]string{"cpu": "100m", "memory": "128Mi"}, Limits: map[string]string{"cpu": "100m", "memory": "1Gi"}},
+		Capabilities:                 []string{"NET_ADMIN"},
+		HostNetwork:                  ptr(true),
+		HostPID:                      ptr(false),
+		AutomountServiceAccountToken: &automount,
+	}, merged)
+	// stored labels must not be modified
+	require.Equal(t, "a", stored.AdditionalPodLabels["team"])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// exit codes of testpod itself. exit codes of remote commands are passed through unchanged
+const (
+	ExitCodeCleanupWarning    = 121
+	ExitCodeSchedulingTimeout = 122
+	ExitCodeClusterError      = 123
+	ExitCodeUsageError        = 124
+	ExitCodeGenericError      = 125
+)
+
+type CategorizedError struct {
+	ExitCode int
+	Err      error
+}
+
+func (e *CategorizedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CategorizedError) Unwrap() error {
+	return e.Err
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &CategorizedError{ExitCode: ExitCodeUsageError, Err: fmt.Errorf(format, args...)}
+}
+
+func clusterError(err error) error {
+	return &CategorizedError{ExitCode: ExitCodeClusterError, Err: err}
+}
+
+func schedulingTimeoutError(err error) error {
+	return &CategorizedError{ExitCode: ExitCodeSchedulingTimeout, Err: err}
+}
+
+func cleanupWarning(err error) error {
+	return &CategorizedError{ExitCode: ExitCodeCleanupWarning, Err: err}
+}
+
+// getExitCode prefers exit codes of remote commands. otherwise the outermost categorized error wins, so callers can recategorize errors by wrapping them
+func getExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var remoteExitErr *RemoteExitError
+	var categorizedErr *CategorizedError
+	if errors.As(err, &remoteExitErr) {
+		return remoteExitErr.ExitCode
+	}
+	if errors.As(err, &categorizedErr) {
+		return categorizedErr.ExitCode
+	}
+	return ExitCodeGenericError
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetExitCode(t *testing.T) {
+	require.Equal(t, 0, getExitCode(nil))
+	require.Equal(t, ExitCodeGenericError, getExitCode(errors.New("something failed")))
+	require.Equal(t, ExitCodeUsageError, getExitCode(fmt.Errorf("read template: %w", usageErrorf("invalid template name %q", "../x"))))
+	require.Equal(t, ExitCodeClusterError, getExitCode(fmt.Errorf("list pods: %w", clusterError(errors.New("exit status 1")))))
+	require.Equal(t, 3, getExitCode(fmt.Errorf("exec into Pod: %w", &RemoteExitError{ExitCode: 3})))
+
+	// the outermost category wins
+	require.Equal(t, ExitCodeSchedulingTimeout, getExitCode(fmt.Errorf("wait for Pod: %w", schedulingTimeoutError(clusterError(errors.New("timed out"))))))
+	require.Equal(t, ExitCodeCleanupWarning, getExitCode(cleanupWarning(fmt.Errorf("delete Pod: %w", clusterError(errors.New("exit status 1"))))))
+}
+
+func TestRemoteExitCodePattern(t *testing.T) {
+	stderr := &tailBuffer{Size: 64}
+	fmt.Fprint(stderr, "some output of the remote command that is longer than the buffer\n")
+	fmt.Fprint(stderr, "command terminated with exit code 42\n")
+	require.Len(t, stderr.Data, 64)
+	m := remoteExitCodePattern.FindSubmatch(stderr.Data)
+	require.NotNil(t, m)
+	require.Equal(t, "42", string(m[1]))
+
+	require.Nil(t, remoteExitCodePattern.FindSubmatch([]byte("Error from server (NotFound): pods \"foo\" not found\n")))
+}
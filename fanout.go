@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+type NodeResult struct {
+	Node     string
+	Output   string
+	ExitCode int
+	// Err is set if the command could not be run on the node, e.g. because the pod was not scheduled
+	Err error
+}
+
+// makeIndexedPodName appends the index to a name from makePodName and shortens the hostname part to keep the timestamp
+func makeIndexedPodName(podName string, index int) string {
+	suffix := fmt.Sprintf("-%d", index)
+	if excess := len(podName) + len(suffix) - 63; excess > 0 {
+		timestampStart := len(podName) - len("-20060102-150405")
+		podName = podName[:timestampStart-excess] + podName[timestampStart:]
+	}
+	return podName + suffix
+}
+
+func SelectNodes(nodes []Node, names []string) ([]Node, error) {
+	if len(names) == 0 {
+		return nodes, nil
+	}
+
+	nodesByName := make(map[string]Node, len(nodes))
+	nodeNames := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		nodesByName[n.Name] = n
+		nodeNames = append(nodeNames, n.Name)
+	}
+	selected := make([]Node, 0, len(names))
+	for _, name := range names {
+		n, ok := nodesByName[name]
+		if !ok {
+			if similar := FindSimilarNames(name, nodeNames); len(similar) > 0 {
+				return nil, usageErrorf("node %q does not exist, did you mean %s?", name, strings.Join(similar, ", "))
+			}
+			return nil, usageErrorf("node %q does not exist", name)
+		}
+		selected = append(selected, n)
+	}
+	return selected, nil
+}
+
+// WriteNodeResults lists cleanup warnings below the table, as the command itself ran on these nodes
+func WriteNodeResults(w io.Writer, results []NodeResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tEXIT\tOUTPUT")
+	warnings := make([]string, 0)
+	for _, r := range results {
+		exitCode := fmt.Sprintf("%d", r.ExitCode)
+		output := strings.TrimRight(r.Output, "\n")
+		if r.Err != nil && getExitCode(r.Err) == ExitCodeCleanupWarning {
+			warnings = append(warnings, fmt.Sprintf("WARN: %s: %s", r.Node, r.Err))
+		} else if r.Err != nil {
+			exitCode = "ERR"
+			output = r.Err.Error()
+		}
+		if len(strings.TrimSpace(output)) == 0 {
+			output = "-"
+		}
+		for i, line := range strings.Split(output, "\n") {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Node, exitCode, line)
+			} else {
+				fmt.Fprintf(tw, "\t\t%s\n", line)
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		if _, err := fmt.Fprintln(w, warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NodeResultsError prefers errors of nodes that did not run the command over failed commands and cleanup warnings
+func NodeResultsError(results []NodeResult) error {
+	var failed []string
+	var firstErr, firstCleanupErr error
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if getExitCode(r.Err) == ExitCodeCleanupWarning {
+			if firstCleanupErr == nil {
+				firstCleanupErr = r.Err
+			}
+			continue
+		}
+		failed = append(failed, r.Node)
+		if firstErr == nil {
+			firstErr = r.Err
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("command could not be run on %d of %d nodes (%s): %w", len(failed), len(results), strings.Join(failed, ", "), firstErr)
+	}
+	for _, r := range results {
+		if r.ExitCode != 0 {
+			return &RemoteExitError{ExitCode: r.ExitCode}
+		}
+	}
+	return firstCleanupErr
+}
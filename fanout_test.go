@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeIndexedPodName(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	require.Equal(t, "testpod-dev-20261018-123000-3", makeIndexedPodName(makePodName("dev", now), 3))
+
+	podName := makeIndexedPodName(makePodName(strings.Repeat("x", 80), now), 12)
+	require.Len(t, podName, 63)
+	require.True(t, strings.HasSuffix(podName, "x-20261018-123000-12"))
+}
+
+func TestSelectNodes(t *testing.T) {
+	nodes := []Node{{Name: "worker-1"}, {Name: "worker-2"}, {Name: "worker-3"}}
+
+	selected, err := SelectNodes(nodes, nil)
+	require.NoError(t, err)
+	require.Equal(t, nodes, selected)
+
+	selected, err = SelectNodes(nodes, []string{"worker-3", "worker-1"})
+	require.NoError(t, err)
+	require.Equal(t, []Node{{Name: "worker-3"}, {Name: "worker-1"}}, selected)
+
+	_, err = SelectNodes(nodes, []string{"worker-4"})
+	require.ErrorContains(t, err, "did you mean")
+	require.Equal(t, ExitCodeUsageError, getExitCode(err))
+}
+
+func TestWriteNodeResults(t *testing.T) {
+	results := []NodeResult{
+		{Node: "worker-1", Output: "Server: 10.96.0.10\nAddress: 10.96.0.10:53\n"},
+		{Node: "worker-2", Output: "", ExitCode: 1},
+		{Node: "worker-3", Err: errors.New("wait for Pod: timed out")},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteNodeResults(&buf, results))
+	require.Equal(t, `NODE       EXIT   OUTPUT
+worker-1   0      Server: 10.96.0.10
+                  Address: 10.96.0.10:53
+worker-2   1      -
+worker-3   ERR    wait for Pod: timed out
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, WriteNodeResults(&buf, []NodeResult{
+		{Node: "worker-1", Output: "ok\n", ExitCode: 3, Err: cleanupWarning(errors.New("delete Pod: exit status 1"))},
+	}))
+	require.Equal(t, `NODE       EXIT   OUTPUT
+worker-1   3      ok
+WARN: worker-1: delete Pod: exit status 1
+`, buf.String())
+}
+
+func TestNodeResultsError(t *testing.T) {
+	require.NoError(t, NodeResultsError([]NodeResult{{Node: "worker-1"}, {Node: "worker-2"}}))
+
+	cleanupErr := cleanupWarning(errors.New("delete Pod: exit status 1"))
+	require.Equal(t, ExitCodeCleanupWarning, getExitCode(NodeResultsError([]NodeResult{{Node: "worker-1", Err: cleanupErr}, {Node: "worker-2"}})))
+	require.Equal(t, 2, getExitCode(NodeResultsError([]NodeResult{{Node: "worker-1", Err: cleanupErr}, {Node: "worker-2", ExitCode: 2}})))
+
+	err := NodeResultsError([]NodeResult{
+		{Node: "worker-1", ExitCode: 2},
+		{Node: "worker-2", Err: fmt.Errorf("wait for Pod: %w", schedulingTimeoutError(errors.New("timed out")))},
+	})
+	require.ErrorContains(t, err, "1 of 2 nodes (worker-2)")
+	require.Equal(t, ExitCodeSchedulingTimeout, getExitCode(err))
+}
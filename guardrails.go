@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	OptionHostNetwork        = "host-network"
+	OptionHostPID            = "host-pid"
+	OptionHostIPC            = "host-ipc"
+	OptionPrivileged         = "privileged"
+	OptionCapabilities       = "cap"
+	OptionNode               = "node"
+	OptionLike               = "like"
+	OptionServiceAccount     = "service-account"
+	OptionEphemeralNamespace = "ephemeral-namespace"
+	OptionNodeShell          = "node-shell"
+	OptionCapture            = "capture"
+
+	ReasonAnnotation = "testpod.io/reason"
+)
+
+type Guardrail struct {
+	Context   string
+	Namespace string
+	// ForbiddenOptions contains all options forbidden by the matching guardrails
+	ForbiddenOptions map[string]bool
+}
+
+func MatchGuardrails(guardrails []GuardrailTemplate, contextName, namespace string) (*Guardrail, error) {
+	var match *Guardrail
+	for _, g := range guardrails {
+		contextMatches, err := matchGlob(g.Context, contextName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid context pattern %q: %w", g.Context, err)
+		}
+		namespaceMatches, err := matchGlob(g.Namespace, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", g.Namespace, err)
+		}
+		if !contextMatches || !namespaceMatches {
+			continue
+		}
+
+		if match == nil {
+			match = &Guardrail{Context: contextName, Namespace: namespace, ForbiddenOptions: make(map[string]bool)}
+		}
+		for _, o := range g.ForbiddenOptions {
+			match.ForbiddenOptions[strings.TrimPrefix(o, "--")] = true
+		}
+	}
+	return match, nil
+}
+
+func matchGlob(pattern, str string) (bool, error) {
+	if len(pattern) == 0 {
+		return true, nil
+	}
+	return path.Match(pattern, str)
+}
+
+func (g *Guardrail) Check(usedOptions []string, reason string) error {
+	for _, o := range usedOptions {
+		if g.ForbiddenOptions[o] {
+			return usageErrorf("option --%s is forbidden in context %q and namespace %q", o, g.Context, g.Namespace)
+		}
+	}
+	if len(strings.TrimSpace(reason)) == 0 {
+		return usageErrorf("context %q and namespace %q are protected, please specify a --reason", g.Context, g.Namespace)
+	}
+	return nil
+}
+
+func GetUsedOptions(pod PodTemplate) []string {
+	options := make([]string, 0)
+	if pod.HostNetwork {
+		options = append(options, OptionHostNetwork)
+	}
+	if pod.HostPID {
+		options = append(options, OptionHostPID)
+	}
+	if pod.HostIPC {
+		options = append(options, OptionHostIPC)
+	}
+	if pod.Privileged {
+		options = append(options, OptionPrivileged)
+	}
+	if len(pod.Capabilities) > 0 {
+		options = append(options, OptionCapabilities)
+	}
+	if len(pod.ServiceAccountName) > 0 {
+		options = append(options, OptionServiceAccount)
+	}
+	return options
+}
+
+// GetUsedOptionsOfPodSpec returns the options an existing pod spec corresponds to, e.g. for clones
+func GetUsedOptionsOfPodSpec(spec map[string]interface{}) []string {
+	options := make([]string, 0)
+	if hostNetwork, _ := spec["hostNetwork"].(bool); hostNetwork {
+		options = append(options, OptionHostNetwork)
+	}
+	if hostPID, _ := spec["hostPID"].(bool); hostPID {
+		options = append(options, OptionHostPID)
+	}
+	if hostIPC, _ := spec["hostIPC"].(bool); hostIPC {
+		options = append(options, OptionHostIPC)
+	}
+	privileged, capabilities := false, false
+	for _, key := range []string{"initContainers", "containers"} {
+		containers, _ := spec[key].([]interface{})
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			securityContext, _ := container["securityContext"].(map[string]interface{})
+			if p, _ := securityContext["privileged"].(bool); p {
+				privileged = true
+			}
+			caps, _ := securityContext["capabilities"].(map[string]interface{})
+			if add, _ := caps["add"].([]interface{}); len(add) > 0 {
+				capabilities = true
+			}
+		}
+	}
+	if privileged {
+		options = append(options, OptionPrivileged)
+	}
+	if capabilities {
+		options = append(options, OptionCapabilities)
+	}
+	if serviceAccountName, _ := spec["serviceAccountName"].(string); len(serviceAccountName) > 0 && serviceAccountName != "default" {
+		options = append(options, OptionServiceAccount)
+	}
+	return options
+}
+
+// GetUsedOptionsOfDebugProfile maps the profiles of kubectl debug to the options they correspond to
+func GetUsedOptionsOfDebugProfile(profile string) []string {
+	switch profile {
+	case "sysadmin":
+		return []string{OptionPrivileged}
+	case "netadmin":
+		return []string{OptionCapabilities}
+	default:
+		return nil
+	}
+}
+
+func checkGuardrails(usedOptions []string, reason string) (*Guardrail, error) {
+	guardrails, err := ReadGuardrails()
+	if err != nil {
+		return nil, fmt.Errorf("read guardrails: %w", err)
+	}
+	if len(guardrails) == 0 {
+		return nil, nil
+	}
+
+	contextName, err := kubectlGetCurrentContext()
+	if err != nil {
+		return nil, fmt.Errorf("get current context: %w", err)
+	}
+	namespace, err := kubectlGetCurrentNamespace()
+	if err != nil {
+		return nil, fmt.Errorf("get current namespace: %w", err)
+	}
+	guardrail, err := MatchGuardrails(guardrails, contextName, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("match guardrails: %w", err)
+	}
+	if guardrail == nil {
+		return nil, nil
+	}
+	if err := guardrail.Check(usedOptions, reason); err != nil {
+		return nil, err
+	}
+	return guardrail, nil
+}
+
+func confirmGuardrail(guardrail *Guardrail) error {
+	fmt.Fprintln(infoOut, "#################################################################")
+	fmt.Fprintln(infoOut, "WARNING: context", guardrail.Context, "and namespace", guardrail.Namespace, "are protected")
+	fmt.Fprintln(infoOut, "#################################################################")
+	if err := InteractiveConfirmByTyping("Type the context name to continue", guardrail.Context); err != nil {
+		return fmt.Errorf("confirm protected context: %w", err)
+	}
+	return nil
+}
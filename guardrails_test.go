@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchGuardrails(t *testing.T) {
+	guardrails := []GuardrailTemplate{
+		{Context: "prod-*", ForbiddenOptions: []string{"--host-network"}},
+		{Context: "prod-*", Namespace: "kube-system", ForbiddenOptions: []string{"privileged", "node-shell"}},
+		{Namespace: "payments"},
+	}
+
+	guardrail, err := MatchGuardrails(guardrails, "dev-cluster", "default")
+	require.NoError(t, err)
+	require.Nil(t, guardrail)
+
+	guardrail, err = MatchGuardrails(guardrails, "prod-eu", "default")
+	require.NoError(t, err)
+	require.Equal(t, &Guardrail{Context: "prod-eu", Namespace: "default", ForbiddenOptions: map[string]bool{"host-network": true}}, guardrail)
+
+	guardrail, err = MatchGuardrails(guardrails, "prod-eu", "kube-system")
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"host-network": true, "privileged": true, "node-shell": true}, guardrail.ForbiddenOptions)
+
+	guardrail, err = MatchGuardrails(guardrails, "dev-cluster", "payments")
+	require.NoError(t, err)
+	require.NotNil(t, guardrail)
+	require.Empty(t, guardrail.ForbiddenOptions)
+
+	_, err = MatchGuardrails([]GuardrailTemplate{{Context: "[prod"}}, "prod", "default")
+	require.Error(t, err)
+}
+
+func TestGuardrailCheck(t *testing.T) {
+	guardrail := &Guardrail{Context: "prod-eu", Namespace: "default", ForbiddenOptions: map[string]bool{"host-network": true}}
+
+	require.Error(t, guardrail.Check(nil, ""))
+	require.Error(t, guardrail.Check(nil, "  "))
+	require.NoError(t, guardrail.Check([]string{OptionNode}, "INC-1234"))
+	require.Error(t, guardrail.Check([]string{OptionNode, OptionHostNetwork}, "INC-1234"))
+
+	require.Equal(t, []string{OptionHostNetwork, OptionPrivileged, OptionCapabilities}, GetUsedOptions(PodTemplate{HostNetwork: true, Privileged: true, Capabilities: []string{"NET_ADMIN"}}))
+}
+
+func TestGetUsedOptionsOfPodSpec(t *testing.T) {
+	spec := map[string]interface{}{
+		"hostNetwork":        true,
+		"serviceAccountName": "backup",
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app"},
+			map[string]interface{}{"name": "sidecar", "securityContext": map[string]interface{}{
+				"privileged":   true,
+				"capabilities": map[string]interface{}{"add": []interface{}{"NET_ADMIN"}},
+			}},
+		},
+	}
+	require.Equal(t, []string{OptionHostNetwork, OptionPrivileged, OptionCapabilities, OptionServiceAccount}, GetUsedOptionsOfPodSpec(spec))
+
+	require.Empty(t, GetUsedOptionsOfPodSpec(map[string]interface{}{"serviceAccountName": "default"}))
+}
+
+func TestGetUsedOptionsOfDebugProfile(t *testing.T) {
+	require.Equal(t, []string{OptionPrivileged}, GetUsedOptionsOfDebugProfile("sysadmin"))
+	require.Equal(t, []string{OptionCapabilities}, GetUsedOptionsOfDebugProfile("netadmin"))
+	require.Empty(t, GetUsedOptionsOfDebugProfile("restricted"))
+}
+
+func TestCheckGuardrailsWithNamedTemplate(t *testing.T) {
+	writeTestConfig(t, map[string]string{
+		"default.json": `{"Guardrails": [{"Context": "prod-*"}]}`,
+		"scratch.json": `{"DefaultImage": "busybox"}`,
+	})
+
+	kubectlContext, kubectlNamespace = "prod-eu", "default"
+	t.Cleanup(func() { kubectlContext, kubectlNamespace = "", "" })
+
+	// guardrails of default.json apply although the selected template has none
+	tpl, err := ReadNamedTemplate("scratch")
+	require.NoError(t, err)
+	require.Empty(t, tpl.Guardrails)
+	_, err = checkGuardrails(GetUsedOptions(tpl.Pod), "")
+	require.Error(t, err)
+	require.Equal(t, ExitCodeUsageError, getExitCode(err))
+
+	guardrail, err := checkGuardrails(GetUsedOptions(tpl.Pod), "INC-1234")
+	require.NoError(t, err)
+	require.Equal(t, "prod-eu", guardrail.Context)
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,16 +17,72 @@ type PodManifest struct {
 	APIVersion string        `yaml:"apiVersion"`
 	Kind       string        `yaml:"kind"`
 	Metadata   MetadataBlock `yaml:"metadata"`
-	Spec       struct {
-		Affinity                      *AffinityBlock   `yaml:"affinity,omitempty"`
-		TerminationGracePeriodSeconds int              `yaml:"terminationGracePeriodSeconds"`
-		Containers                    []ContainerBlock `yaml:"containers"`
-	} `yaml:"spec"`
+	Spec       PodSpecBlock  `yaml:"spec"`
+}
+
+type NamespaceManifest struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   MetadataBlock `yaml:"metadata"`
+}
+
+type JobManifest struct {
+	APIVersion string        `yaml:"apiVersion"`
+	Kind       string        `yaml:"kind"`
+	Metadata   MetadataBlock `yaml:"metadata"`
+	Spec       JobSpecBlock  `yaml:"spec"`
+}
+
+type JobSpecBlock struct {
+	BackoffLimit            int              `yaml:"backoffLimit"`
+	TTLSecondsAfterFinished int              `yaml:"ttlSecondsAfterFinished"`
+	Template                PodTemplateBlock `yaml:"template"`
+}
+
+type PodTemplateBlock struct {
+	Metadata MetadataBlock `yaml:"metadata"`
+	Spec     PodSpecBlock  `yaml:"spec"`
+}
+
+type PodSpecBlock struct {
+	Affinity                      *AffinityBlock           `yaml:"affinity,omitempty"`
+	RestartPolicy                 string                   `yaml:"restartPolicy,omitempty"`
+	TerminationGracePeriodSeconds int                      `yaml:"terminationGracePeriodSeconds"`
+	HostNetwork                   bool                     `yaml:"hostNetwork,omitempty"`
+	HostPID                       bool                     `yaml:"hostPID,omitempty"`
+	HostIPC                       bool                     `yaml:"hostIPC,omitempty"`
+	DNSPolicy                     string                   `yaml:"dnsPolicy,omitempty"`
+	ServiceAccountName            string                   `yaml:"serviceAccountName,omitempty"`
+	AutomountServiceAccountToken  *bool                    `yaml:"automountServiceAccountToken,omitempty"`
+	NodeSelector                  map[string]string        `yaml:"nodeSelector,omitempty"`
+	Tolerations                   []TolerationBlock        `yaml:"tolerations,omitempty"`
+	ImagePullSecrets              []interface{}            `yaml:"imagePullSecrets,omitempty"`
+	Volumes                       []interface{}            `yaml:"volumes,omitempty"`
+	SecurityContext               *PodSecurityContextBlock `yaml:"securityContext,omitempty"`
+	InitContainers                []ContainerBlock         `yaml:"initContainers,omitempty"`
+	Containers                    []ContainerBlock         `yaml:"containers"`
+}
+
+type TolerationBlock struct {
+	Key               string `yaml:"key,omitempty" json:"key"`
+	Operator          string `yaml:"operator,omitempty" json:"operator"`
+	Value             string `yaml:"value,omitempty" json:"value"`
+	Effect            string `yaml:"effect,omitempty" json:"effect"`
+	TolerationSeconds *int64 `yaml:"tolerationSeconds,omitempty" json:"tolerationSeconds"`
 }
 
 type MetadataBlock struct {
-	Name   string            `yaml:"name"`
-	Labels map[string]string `yaml:"labels,omitempty"`
+	Name        string            `yaml:"name,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// SetAnnotation keeps other annotations like the default container
+func (m *MetadataBlock) SetAnnotation(key, value string) {
+	if m.Annotations == nil {
+		m.Annotations = make(map[string]string)
+	}
+	m.Annotations[key] = value
 }
 
 type AffinityBlock struct {
@@ -44,10 +104,43 @@ type MatchExpressionsBlock struct {
 }
 
 type ContainerBlock struct {
-	Name    string   `yaml:"name"`
-	Image   string   `yaml:"image"`
-	Command []string `yaml:"command"`
-	Args    []string `yaml:"args"`
+	Name            string                         `yaml:"name"`
+	Image           string                         `yaml:"image"`
+	Command         []string                       `yaml:"command"`
+	Args            []string                       `yaml:"args"`
+	Env             []interface{}                  `yaml:"env,omitempty"`
+	EnvFrom         []interface{}                  `yaml:"envFrom,omitempty"`
+	VolumeMounts    []interface{}                  `yaml:"volumeMounts,omitempty"`
+	Resources       *ResourcesBlock                `yaml:"resources,omitempty"`
+	SecurityContext *ContainerSecurityContextBlock `yaml:"securityContext,omitempty"`
+	Stdin           bool                           `yaml:"stdin,omitempty"`
+	StdinOnce       bool                           `yaml:"stdinOnce,omitempty"`
+}
+
+type ResourcesBlock struct {
+	Requests map[string]string `yaml:"requests,omitempty"`
+	Limits   map[string]string `yaml:"limits,omitempty"`
+}
+
+type PodSecurityContextBlock struct {
+	RunAsNonRoot   *bool                `yaml:"runAsNonRoot,omitempty"`
+	RunAsUser      *int64               `yaml:"runAsUser,omitempty"`
+	SeccompProfile *SeccompProfileBlock `yaml:"seccompProfile,omitempty"`
+}
+
+type SeccompProfileBlock struct {
+	Type string `yaml:"type"`
+}
+
+type ContainerSecurityContextBlock struct {
+	Privileged               *bool              `yaml:"privileged,omitempty"`
+	AllowPrivilegeEscalation *bool              `yaml:"allowPrivilegeEscalation,omitempty"`
+	Capabilities             *CapabilitiesBlock `yaml:"capabilities,omitempty"`
+}
+
+type CapabilitiesBlock struct {
+	Add  []string `yaml:"add,omitempty"`
+	Drop []string `yaml:"drop,omitempty"`
 }
 
 type NetworkPolicyManifest struct {
@@ -58,36 +151,83 @@ type NetworkPolicyManifest struct {
 		PodSelector struct {
 			MatchLabels map[string]string `yaml:"matchLabels"`
 		} `yaml:"podSelector"`
-		Egress []EgressBlock `yaml:"egress"`
+		PolicyTypes []string       `yaml:"policyTypes,omitempty"`
+		Egress      []EgressBlock  `yaml:"egress,omitempty"`
+		Ingress     []IngressBlock `yaml:"ingress,omitempty"`
 	} `yaml:"spec"`
 }
 
 type EgressBlock struct {
-	Ports []PortBlock `yaml:"ports"`
+	Ports []PortBlock `yaml:"ports,omitempty"`
+	To    []PeerBlock `yaml:"to,omitempty"`
+}
+
+type IngressBlock struct {
+	Ports []PortBlock `yaml:"ports,omitempty"`
+	From  []PeerBlock `yaml:"from,omitempty"`
 }
 
 type PortBlock struct {
 	Protocol string `yaml:"protocol"`
-	Port     int    `yaml:"port"`
-	EndPort  int    `yaml:"endPort"`
+	Port     int    `yaml:"port,omitempty"`
+	EndPort  int    `yaml:"endPort,omitempty"`
+}
+
+type PeerBlock struct {
+	IPBlock           *IPBlockBlock       `yaml:"ipBlock,omitempty"`
+	NamespaceSelector *LabelSelectorBlock `yaml:"namespaceSelector,omitempty"`
+	PodSelector       *LabelSelectorBlock `yaml:"podSelector,omitempty"`
+}
+
+type IPBlockBlock struct {
+	CIDR string `yaml:"cidr"`
+}
+
+type LabelSelectorBlock struct {
+	MatchLabels map[string]string `yaml:"matchLabels,omitempty"`
 }
 
 type Node struct {
 	Name    string
 	Age     time.Duration
 	Version string
+	Taints  []Taint
 }
 
-func MakeManifestFromTemplate(managedBy, name string, nodeLabels map[string]string, tpl Template) (string, error) {
-	if len(name) == 0 {
-		return "", fmt.Errorf("name cannot be empty")
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+func MakeNamespaceManifest(managedBy, name string) (string, error) {
+	var namespaceManifest NamespaceManifest
+	namespaceManifest.APIVersion = "v1"
+	namespaceManifest.Kind = "Namespace"
+	namespaceManifest.Metadata.Name = name
+	namespaceManifest.Metadata.Labels = MakeMatchLabels(managedBy, name)
+
+	namespaceYaml, err := yaml.Marshal(&namespaceManifest)
+	if err != nil {
+		return "", fmt.Errorf("marshal namespace yaml: %w", err)
 	}
+	return string(namespaceYaml), nil
+}
 
-	matchLabels := map[string]string{
+func MakeMatchLabels(managedBy, name string) map[string]string {
+	return map[string]string{
 		"app.kubernetes.io/name":       "go-testpod",
 		"app.kubernetes.io/instance":   name,
 		"app.kubernetes.io/managed-by": managedBy,
 	}
+}
+
+func MakePodManifestFromTemplate(managedBy, name string, nodeLabels map[string]string, tpl Template) (PodManifest, error) {
+	if len(name) == 0 {
+		return PodManifest{}, fmt.Errorf("name cannot be empty")
+	}
+
+	matchLabels := MakeMatchLabels(managedBy, name)
 
 	var podManifest PodManifest
 	podManifest.APIVersion = "v1"
@@ -101,9 +241,54 @@ func MakeManifestFromTemplate(managedBy, name string, nodeLabels map[string]stri
 		podManifest.Metadata.Labels[k] = v
 	}
 	podManifest.Spec.TerminationGracePeriodSeconds = 1
+	podManifest.Spec.ServiceAccountName = tpl.Pod.ServiceAccountName
+	podManifest.Spec.AutomountServiceAccountToken = tpl.Pod.AutomountServiceAccountToken
 	podManifest.Spec.Containers = []ContainerBlock{
 		{Name: "main", Image: tpl.DefaultImage, Command: tpl.Pod.Command, Args: tpl.Pod.Args},
 	}
+	if len(tpl.Pod.Resources.Requests) > 0 || len(tpl.Pod.Resources.Limits) > 0 {
+		podManifest.Spec.Containers[0].Resources = &ResourcesBlock{
+			Requests: tpl.Pod.Resources.Requests,
+			Limits:   tpl.Pod.Resources.Limits,
+		}
+	}
+	if len(tpl.Pod.AgentBinary) > 0 {
+		addAgentInitContainer(&podManifest, tpl.AgentInitImage)
+	}
+	if err := applySecurityPreset(&podManifest, tpl.Pod.Security, tpl.Pod.Capabilities); err != nil {
+		return PodManifest{}, err
+	}
+	if tpl.Pod.Privileged {
+		if tpl.Pod.Security != SecurityPresetPrivileged {
+			return PodManifest{}, usageErrorf("privileged containers are not allowed for security preset %s", tpl.Pod.Security)
+		}
+		for i := range podManifest.Spec.Containers {
+			if podManifest.Spec.Containers[i].SecurityContext == nil {
+				podManifest.Spec.Containers[i].SecurityContext = &ContainerSecurityContextBlock{}
+			}
+			podManifest.Spec.Containers[i].SecurityContext.Privileged = ptr(true)
+		}
+	}
+	for _, t := range tpl.Pod.Tolerations {
+		podManifest.Spec.Tolerations = append(podManifest.Spec.Tolerations, TolerationBlock{
+			Key:      t.Key,
+			Operator: t.Operator,
+			Value:    t.Value,
+			Effect:   t.Effect,
+		})
+	}
+	if tpl.Pod.UsesHostNamespaces() {
+		if tpl.Pod.Security != SecurityPresetPrivileged && len(tpl.Pod.Security) > 0 {
+			return PodManifest{}, usageErrorf("host namespaces are not allowed for security preset %s", tpl.Pod.Security)
+		}
+		podManifest.Spec.HostNetwork = tpl.Pod.HostNetwork
+		podManifest.Spec.HostPID = tpl.Pod.HostPID
+		podManifest.Spec.HostIPC = tpl.Pod.HostIPC
+		if tpl.Pod.HostNetwork {
+			// keep cluster dns resolution working in the host network namespace
+			podManifest.Spec.DNSPolicy = "ClusterFirstWithHostNet"
+		}
+	}
 	if len(nodeLabels) > 0 {
 		selectors := make([]MatchExpressionsBlock, 0, len(nodeLabels))
 		for k, v := range nodeLabels {
@@ -118,24 +303,162 @@ func MakeManifestFromTemplate(managedBy, name string, nodeLabels map[string]stri
 			{MatchExpressions: selectors},
 		}
 	}
-	podYaml, err := yaml.Marshal(&podManifest)
+	return podManifest, nil
+}
+
+// MakeJobManifestFromTemplate runs the command once without retries. the job is deleted after ttl as a backstop if testpod fails to clean it up
+func MakeJobManifestFromTemplate(managedBy, name string, nodeLabels map[string]string, tpl Template, command []string, ttl time.Duration) (JobManifest, error) {
+	if len(command) == 0 {
+		return JobManifest{}, usageErrorf("command cannot be empty")
+	}
+	podManifest, err := MakePodManifestFromTemplate(managedBy, name, nodeLabels, tpl)
+	if err != nil {
+		return JobManifest{}, err
+	}
+	podManifest.Spec.RestartPolicy = "Never"
+	podManifest.Spec.Containers[0].Command = command
+	podManifest.Spec.Containers[0].Args = nil
+
+	var jobManifest JobManifest
+	jobManifest.APIVersion = "batch/v1"
+	jobManifest.Kind = "Job"
+	jobManifest.Metadata = podManifest.Metadata
+	jobManifest.Spec.BackoffLimit = 0
+	jobManifest.Spec.TTLSecondsAfterFinished = int(ttl.Seconds())
+	// pod names are generated by the job controller
+	jobManifest.Spec.Template.Metadata = MetadataBlock{Labels: podManifest.Metadata.Labels, Annotations: podManifest.Metadata.Annotations}
+	jobManifest.Spec.Template.Spec = podManifest.Spec
+	return jobManifest, nil
+}
+
+func MakeNetworkPolicyManifestFromTemplate(managedBy, name string, tpl Template) (*NetworkPolicyManifest, error) {
+	if !tpl.NetworkPolicy.Enabled() {
+		return nil, nil
+	}
+
+	var networkPolicyManifest NetworkPolicyManifest
+	networkPolicyManifest.APIVersion = "networking.k8s.io/v1"
+	networkPolicyManifest.Kind = "NetworkPolicy"
+	networkPolicyManifest.Metadata.Name = name
+	networkPolicyManifest.Spec.PodSelector.MatchLabels = MakeMatchLabels(managedBy, name)
+
+	egressRules := append([]NetworkPolicyRuleTemplate{}, tpl.NetworkPolicy.Egress...)
+	ingressRules := append([]NetworkPolicyRuleTemplate{}, tpl.NetworkPolicy.Ingress...)
+	presets := tpl.NetworkPolicy.Presets
+	if tpl.NetworkPolicy.CreateAllowAll {
+		presets = append([]string{NetworkPolicyPresetAllowAllTCP}, presets...)
+	}
+	for _, preset := range presets {
+		rules, ok := networkPolicyPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown network policy preset %q", preset)
+		}
+		egressRules = append(egressRules, rules.Egress...)
+		ingressRules = append(ingressRules, rules.Ingress...)
+	}
+
+	for _, rule := range egressRules {
+		ports, peers, err := makeNetworkPolicyRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("egress rule: %w", err)
+		}
+		networkPolicyManifest.Spec.Egress = append(networkPolicyManifest.Spec.Egress, EgressBlock{Ports: ports, To: peers})
+	}
+	for _, rule := range ingressRules {
+		ports, peers, err := makeNetworkPolicyRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("ingress rule: %w", err)
+		}
+		networkPolicyManifest.Spec.Ingress = append(networkPolicyManifest.Spec.Ingress, IngressBlock{Ports: ports, From: peers})
+	}
+	// only isolate the directions that are configured, so that the testpod stays reachable otherwise
+	if len(networkPolicyManifest.Spec.Ingress) > 0 {
+		networkPolicyManifest.Spec.PolicyTypes = append(networkPolicyManifest.Spec.PolicyTypes, "Ingress")
+	}
+	if len(networkPolicyManifest.Spec.Egress) > 0 {
+		networkPolicyManifest.Spec.PolicyTypes = append(networkPolicyManifest.Spec.PolicyTypes, "Egress")
+	}
+	return &networkPolicyManifest, nil
+}
+
+const (
+	NetworkPolicyPresetAllowAllTCP          = "allow-all-tcp"
+	NetworkPolicyPresetDNSOnly              = "dns-only"
+	NetworkPolicyPresetEgressAll            = "egress-all"
+	NetworkPolicyPresetIngressFromNamespace = "ingress-from-namespace"
+)
+
+var networkPolicyPresets = map[string]struct {
+	Egress  []NetworkPolicyRuleTemplate
+	Ingress []NetworkPolicyRuleTemplate
+}{
+	NetworkPolicyPresetAllowAllTCP: {
+		Egress: []NetworkPolicyRuleTemplate{
+			{Ports: []NetworkPolicyPortTemplate{{Protocol: "TCP", Port: 1, EndPort: 65535}}},
+		},
+	},
+	NetworkPolicyPresetDNSOnly: {
+		Egress: []NetworkPolicyRuleTemplate{
+			{Ports: []NetworkPolicyPortTemplate{{Protocol: "UDP", Port: 53}, {Protocol: "TCP", Port: 53}}},
+		},
+	},
+	NetworkPolicyPresetEgressAll: {
+		Egress: []NetworkPolicyRuleTemplate{{}},
+	},
+	NetworkPolicyPresetIngressFromNamespace: {
+		Ingress: []NetworkPolicyRuleTemplate{
+			{PodSelector: map[string]string{}},
+		},
+	},
+}
+
+func makeNetworkPolicyRule(rule NetworkPolicyRuleTemplate) ([]PortBlock, []PeerBlock, error) {
+	ports := make([]PortBlock, 0, len(rule.Ports))
+	for _, p := range rule.Ports {
+		protocol := strings.ToUpper(p.Protocol)
+		if len(protocol) == 0 {
+			protocol = "TCP"
+		}
+		if protocol != "TCP" && protocol != "UDP" && protocol != "SCTP" {
+			return nil, nil, fmt.Errorf("unsupported protocol %q", p.Protocol)
+		}
+		if p.EndPort > 0 && p.EndPort < p.Port {
+			return nil, nil, fmt.Errorf("end port %d is lower than port %d", p.EndPort, p.Port)
+		}
+		ports = append(ports, PortBlock{Protocol: protocol, Port: p.Port, EndPort: p.EndPort})
+	}
+
+	peers := make([]PeerBlock, 0, len(rule.CIDRs)+1)
+	for _, cidr := range rule.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, nil, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		peers = append(peers, PeerBlock{IPBlock: &IPBlockBlock{CIDR: cidr}})
+	}
+	if rule.NamespaceSelector != nil || rule.PodSelector != nil {
+		var peer PeerBlock
+		if rule.NamespaceSelector != nil {
+			peer.NamespaceSelector = &LabelSelectorBlock{MatchLabels: rule.NamespaceSelector}
+		}
+		if rule.PodSelector != nil {
+			peer.PodSelector = &LabelSelectorBlock{MatchLabels: rule.PodSelector}
+		}
+		peers = append(peers, peer)
+	}
+	return ports, peers, nil
+}
+
+func MarshalManifests(podManifest interface{}, networkPolicyManifest *NetworkPolicyManifest) (string, error) {
+	podYaml, err := yaml.Marshal(podManifest)
 	if err != nil {
 		return "", fmt.Errorf("marshal pod yaml: %w", err)
 	}
 	fullYaml := string(podYaml)
 
-	if tpl.NetworkPolicy.CreateAllowAll {
-		var networkPolicyManifest NetworkPolicyManifest
-		networkPolicyManifest.APIVersion = "networking.k8s.io/v1"
-		networkPolicyManifest.Kind = "NetworkPolicy"
-		networkPolicyManifest.Metadata.Name = name
-		networkPolicyManifest.Spec.PodSelector.MatchLabels = matchLabels
-		networkPolicyManifest.Spec.Egress = []EgressBlock{
-			{Ports: []PortBlock{{Protocol: "TCP", Port: 1, EndPort: 65535}}},
-		}
-		nwPolYaml, err := yaml.Marshal(&networkPolicyManifest)
+	if networkPolicyManifest != nil {
+		nwPolYaml, err := yaml.Marshal(networkPolicyManifest)
 		if err != nil {
-			return "", fmt.Errorf("marshal pod yaml: %w", err)
+			return "", fmt.Errorf("marshal network policy yaml: %w", err)
 		}
 
 		fullYaml += "\n---\n" + string(nwPolYaml)
@@ -144,6 +467,332 @@ func MakeManifestFromTemplate(managedBy, name string, nodeLabels map[string]stri
 	return fullYaml, nil
 }
 
+func MakeCloneManifest(managedBy, name string, pod map[string]interface{}, containerName string, tpl Template) (map[string]interface{}, error) {
+	spec, ok := pod["spec"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pod has no spec")
+	}
+	containers, _ := spec["containers"].([]interface{})
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("pod has no containers")
+	}
+
+	cloneSpec := make(map[string]interface{})
+	for k, v := range spec {
+		cloneSpec[k] = v
+	}
+	// let the scheduler decide again and drop everything that is bound to the original pod
+	delete(cloneSpec, "nodeName")
+	delete(cloneSpec, "ephemeralContainers")
+	cloneSpec["terminationGracePeriodSeconds"] = 1
+
+	cloneContainers := make([]interface{}, 0, len(containers))
+	found := false
+	for i, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid container at index %d", i)
+		}
+		cloneContainer := make(map[string]interface{})
+		for k, v := range container {
+			cloneContainer[k] = v
+		}
+		delete(cloneContainer, "livenessProbe")
+		delete(cloneContainer, "readinessProbe")
+		delete(cloneContainer, "startupProbe")
+		if len(containerName) == 0 && i == 0 {
+			containerName, _ = container["name"].(string)
+		}
+		if container["name"] == containerName {
+			cloneContainer["command"] = tpl.Pod.Command
+			cloneContainer["args"] = tpl.Pod.Args
+			found = true
+		}
+		cloneContainers = append(cloneContainers, cloneContainer)
+	}
+	if !found {
+		return nil, fmt.Errorf("pod has no container %q", containerName)
+	}
+	cloneSpec["containers"] = cloneContainers
+
+	labels := MakeMatchLabels(managedBy, name)
+	for k, v := range tpl.Pod.AdditionalLabels {
+		labels[k] = v
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": labels,
+			"annotations": map[string]string{
+				"kubectl.kubernetes.io/default-container": containerName,
+			},
+		},
+		"spec": cloneSpec,
+	}, nil
+}
+
+type WorkloadPodTemplate struct {
+	Labels map[string]string
+	Spec   struct {
+		ServiceAccountName string              `json:"serviceAccountName"`
+		NodeSelector       map[string]string   `json:"nodeSelector"`
+		Tolerations        []TolerationBlock   `json:"tolerations"`
+		ImagePullSecrets   []interface{}       `json:"imagePullSecrets"`
+		Volumes            []interface{}       `json:"volumes"`
+		Containers         []WorkloadContainer `json:"containers"`
+	}
+}
+
+type WorkloadContainer struct {
+	Name         string        `json:"name"`
+	Env          []interface{} `json:"env"`
+	EnvFrom      []interface{} `json:"envFrom"`
+	VolumeMounts []interface{} `json:"volumeMounts"`
+}
+
+const (
+	LikeAspectServiceAccount   = "service-account"
+	LikeAspectEnv              = "env"
+	LikeAspectVolumes          = "volumes"
+	LikeAspectNodeSelector     = "node-selector"
+	LikeAspectTolerations      = "tolerations"
+	LikeAspectImagePullSecrets = "image-pull-secrets"
+	LikeAspectLabels           = "labels"
+)
+
+var AllLikeAspects = []string{
+	LikeAspectServiceAccount,
+	LikeAspectEnv,
+	LikeAspectVolumes,
+	LikeAspectNodeSelector,
+	LikeAspectTolerations,
+	LikeAspectImagePullSecrets,
+	LikeAspectLabels,
+}
+
+func SelectLikeAspects(include, exclude []string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	for _, a := range AllLikeAspects {
+		known[a] = true
+	}
+	for _, a := range append(append([]string{}, include...), exclude...) {
+		if !known[a] {
+			return nil, usageErrorf("unknown aspect %q, must be one of %s", a, strings.Join(AllLikeAspects, ", "))
+		}
+	}
+
+	aspects := make(map[string]bool)
+	if len(include) > 0 {
+		for _, a := range include {
+			aspects[a] = true
+		}
+	} else {
+		for _, a := range AllLikeAspects {
+			aspects[a] = true
+		}
+	}
+	for _, a := range exclude {
+		delete(aspects, a)
+	}
+	return aspects, nil
+}
+
+// returns the names of workload labels that conflict with testpod labels
+func MergeWorkloadIntoPodManifest(podManifest *PodManifest, workload WorkloadPodTemplate, aspects map[string]bool) []string {
+	// a service account given by --service-account or the template takes precedence, and workloads without service account keep the default
+	if aspects[LikeAspectServiceAccount] && len(workload.Spec.ServiceAccountName) > 0 && len(podManifest.Spec.ServiceAccountName) == 0 {
+		podManifest.Spec.ServiceAccountName = workload.Spec.ServiceAccountName
+	}
+	if aspects[LikeAspectNodeSelector] && len(workload.Spec.NodeSelector) > 0 {
+		if podManifest.Spec.NodeSelector == nil {
+			podManifest.Spec.NodeSelector = make(map[string]string)
+		}
+		for k, v := range workload.Spec.NodeSelector {
+			podManifest.Spec.NodeSelector[k] = v
+		}
+	}
+	if aspects[LikeAspectTolerations] {
+		podManifest.Spec.Tolerations = append(podManifest.Spec.Tolerations, workload.Spec.Tolerations...)
+	}
+	if aspects[LikeAspectImagePullSecrets] {
+		podManifest.Spec.ImagePullSecrets = append(podManifest.Spec.ImagePullSecrets, workload.Spec.ImagePullSecrets...)
+	}
+	if len(workload.Spec.Containers) > 0 {
+		// env and mounts are taken from the first container, which is the application container for most workloads
+		container := workload.Spec.Containers[0]
+		if aspects[LikeAspectEnv] {
+			for i := range podManifest.Spec.Containers {
+				podManifest.Spec.Containers[i].Env = append(podManifest.Spec.Containers[i].Env, container.Env...)
+				podManifest.Spec.Containers[i].EnvFrom = append(podManifest.Spec.Containers[i].EnvFrom, container.EnvFrom...)
+			}
+		}
+		if aspects[LikeAspectVolumes] {
+			podManifest.Spec.Volumes = append(podManifest.Spec.Volumes, workload.Spec.Volumes...)
+			for i := range podManifest.Spec.Containers {
+				podManifest.Spec.Containers[i].VolumeMounts = append(podManifest.Spec.Containers[i].VolumeMounts, container.VolumeMounts...)
+			}
+		}
+	}
+
+	skippedLabels := make([]string, 0)
+	if aspects[LikeAspectLabels] {
+		for k, v := range workload.Labels {
+			if _, ok := podManifest.Metadata.Labels[k]; ok {
+				// never overwrite testpod labels, they are required to find and clean up the pod
+				if podManifest.Metadata.Labels[k] != v {
+					skippedLabels = append(skippedLabels, k)
+				}
+				continue
+			}
+			podManifest.Metadata.Labels[k] = v
+		}
+	}
+	sort.Strings(skippedLabels)
+	return skippedLabels
+}
+
+func MatchesSelector(selector, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	SecurityPresetRestricted = "restricted"
+	SecurityPresetBaseline   = "baseline"
+	SecurityPresetPrivileged = "privileged"
+)
+
+// capabilities that may be added to containers by the baseline Pod Security Standard
+var baselineCapabilities = map[string]bool{
+	"AUDIT_WRITE":      true,
+	"CHOWN":            true,
+	"DAC_OVERRIDE":     true,
+	"FOWNER":           true,
+	"FSETID":           true,
+	"KILL":             true,
+	"MKNOD":            true,
+	"NET_BIND_SERVICE": true,
+	"SETFCAP":          true,
+	"SETGID":           true,
+	"SETPCAP":          true,
+	"SETUID":           true,
+	"SYS_CHROOT":       true,
+}
+
+func applySecurityPreset(podManifest *PodManifest, preset string, capabilities []string) error {
+	addCapabilities := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		addCapabilities = append(addCapabilities, strings.TrimPrefix(strings.ToUpper(c), "CAP_"))
+	}
+
+	switch preset {
+	case SecurityPresetRestricted:
+		for _, c := range addCapabilities {
+			if c != "NET_BIND_SERVICE" {
+				return usageErrorf("capability %s is not allowed for security preset %s", c, preset)
+			}
+		}
+		podManifest.Spec.SecurityContext = &PodSecurityContextBlock{
+			RunAsNonRoot: ptr(true),
+			// most images run as root by default and would be refused by runAsNonRoot, so fall back to nobody
+			RunAsUser:      ptr(int64(65534)),
+			SeccompProfile: &SeccompProfileBlock{Type: "RuntimeDefault"},
+		}
+		for i := range podManifest.Spec.Containers {
+			podManifest.Spec.Containers[i].SecurityContext = &ContainerSecurityContextBlock{
+				AllowPrivilegeEscalation: ptr(false),
+				Capabilities: &CapabilitiesBlock{
+					Add:  addCapabilities,
+					Drop: []string{"ALL"},
+				},
+			}
+		}
+		for i := range podManifest.Spec.InitContainers {
+			podManifest.Spec.InitContainers[i].SecurityContext = &ContainerSecurityContextBlock{
+				AllowPrivilegeEscalation: ptr(false),
+				Capabilities:             &CapabilitiesBlock{Drop: []string{"ALL"}},
+			}
+		}
+
+	case SecurityPresetBaseline, SecurityPresetPrivileged, "":
+		if preset == SecurityPresetBaseline {
+			for _, c := range addCapabilities {
+				if !baselineCapabilities[c] {
+					return usageErrorf("capability %s is not allowed for security preset %s", c, preset)
+				}
+			}
+		}
+		if len(addCapabilities) > 0 {
+			for i := range podManifest.Spec.Containers {
+				podManifest.Spec.Containers[i].SecurityContext = &ContainerSecurityContextBlock{
+					Capabilities: &CapabilitiesBlock{Add: addCapabilities},
+				}
+			}
+		}
+
+	default:
+		return usageErrorf("unknown security preset %q", preset)
+	}
+	return nil
+}
+
+// addAgentInitContainer shares an emptyDir with all containers. the init container writes the agent binary received on stdin to it, see injectAgent
+func addAgentInitContainer(podManifest *PodManifest, initImage string) {
+	if len(initImage) == 0 {
+		initImage = DefaultAgentInitImage
+	}
+	volumeMount := map[string]interface{}{"name": AgentVolumeName, "mountPath": AgentDir}
+	podManifest.Spec.Volumes = append(podManifest.Spec.Volumes, map[string]interface{}{
+		"name":     AgentVolumeName,
+		"emptyDir": map[string]interface{}{},
+	})
+	for i := range podManifest.Spec.Containers {
+		podManifest.Spec.Containers[i].VolumeMounts = append(podManifest.Spec.Containers[i].VolumeMounts, volumeMount)
+	}
+	// kubectl exec would otherwise print which container it defaulted to, which breaks parsing the output
+	podManifest.Metadata.SetAnnotation("kubectl.kubernetes.io/default-container", podManifest.Spec.Containers[0].Name)
+	podManifest.Spec.InitContainers = append(podManifest.Spec.InitContainers, ContainerBlock{
+		Name:         AgentContainerName,
+		Image:        initImage,
+		Command:      []string{"sh", "-c", fmt.Sprintf("cat > %s && chmod 755 %s", AgentPath, AgentPath)},
+		Args:         []string{},
+		VolumeMounts: []interface{}{volumeMount},
+		Stdin:        true,
+		StdinOnce:    true,
+	})
+}
+
+func MakeTolerationsForTaints(taints []Taint) []TolerationTemplate {
+	tolerations := make([]TolerationTemplate, 0, len(taints))
+	for _, t := range taints {
+		if len(t.Value) > 0 {
+			tolerations = append(tolerations, TolerationTemplate{Key: t.Key, Operator: "Equal", Value: t.Value, Effect: t.Effect})
+		} else {
+			tolerations = append(tolerations, TolerationTemplate{Key: t.Key, Operator: "Exists", Effect: t.Effect})
+		}
+	}
+	return tolerations
+}
+
+// isTrue treats unset flags as false
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func makePodName(hostname string, now time.Time) string {
 	// return a name that complies with RFC 1123 and RFC 1035 rules
 	hostname = strings.ToLower(hostname)
@@ -159,3 +808,54 @@ func makePodName(hostname string, now time.Time) string {
 
 	return prefix + hostname + suffix
 }
+
+var quantitySuffixes = map[string]float64{
+	"":   1,
+	"m":  1e-3,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"P":  1e15,
+	"E":  1e18,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+var quantityPartsPattern = regexp.MustCompile(`^([0-9.]+)([a-zA-Z]*)$`)
+
+func ParseQuantity(str string) (float64, error) {
+	m := quantityPartsPattern.FindStringSubmatch(strings.TrimSpace(str))
+	if m == nil {
+		return 0, fmt.Errorf("invalid quantity %q", str)
+	}
+	factor, ok := quantitySuffixes[m[2]]
+	if !ok {
+		return 0, fmt.Errorf("invalid quantity suffix %q", m[2])
+	}
+	val, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q: %w", str, err)
+	}
+	return val * factor, nil
+}
+
+func FormatQuantity(resourceName string, val float64) string {
+	switch {
+	case strings.HasSuffix(resourceName, "cpu"):
+		return fmt.Sprintf("%dm", int64(math.Round(val*1000)))
+	case strings.HasSuffix(resourceName, "memory") || strings.HasSuffix(resourceName, "storage"):
+		for _, suffix := range []string{"Ei", "Pi", "Ti", "Gi", "Mi", "Ki"} {
+			if math.Abs(val) >= quantitySuffixes[suffix] {
+				return strconv.FormatFloat(math.Round(val/quantitySuffixes[suffix]*100)/100, 'f', -1, 64) + suffix
+			}
+		}
+		return strconv.FormatFloat(val, 'f', 0, 64)
+	default:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+}
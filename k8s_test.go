@@ -18,3 +18,214 @@ func TestMakePodName(t *testing.T) {
 	require.Equal(t, "testpod-this-hostname-has-perfectly-fine-length-20240317-041507", makePodName("this-hostname-has-perfectly-fine-length", time.Date(2024, time.March, 17, 4, 15, 7, 0, time.Local)))
 	require.Equal(t, "testpod-this-hostname-is-just-one1-char-too-lon-20240317-041507", makePodName("this-hostname-is-just-one1-char-too-long", time.Date(2024, time.March, 17, 4, 15, 7, 0, time.Local)))
 }
+
+func TestQuantities(t *testing.T) {
+	val, err := ParseQuantity("500m")
+	require.NoError(t, err)
+	require.InDelta(t, 0.5, val, 1e-9)
+	val, err = ParseQuantity("2Gi")
+	require.NoError(t, err)
+	require.Equal(t, float64(2<<30), val)
+	_, err = ParseQuantity("2 apples")
+	require.Error(t, err)
+
+	require.Equal(t, "1500m", FormatQuantity("requests.cpu", 1.5))
+	require.Equal(t, "1.5Gi", FormatQuantity("limits.memory", 1.5*(1<<30)))
+	require.Equal(t, "512Mi", FormatQuantity("requests.ephemeral-storage", 512*(1<<20)))
+	require.Equal(t, "3", FormatQuantity("pods", 3))
+}
+
+func TestApplySecurityPreset(t *testing.T) {
+	newManifest := func() *PodManifest {
+		var podManifest PodManifest
+		podManifest.Spec.Containers = []ContainerBlock{{Name: "main"}}
+		return &podManifest
+	}
+
+	podManifest := newManifest()
+	require.NoError(t, applySecurityPreset(podManifest, SecurityPresetRestricted, nil))
+	require.Equal(t, true, *podManifest.Spec.SecurityContext.RunAsNonRoot)
+	require.Equal(t, "RuntimeDefault", podManifest.Spec.SecurityContext.SeccompProfile.Type)
+	require.Equal(t, false, *podManifest.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation)
+	require.Equal(t, []string{"ALL"}, podManifest.Spec.Containers[0].SecurityContext.Capabilities.Drop)
+	require.Error(t, applySecurityPreset(newManifest(), SecurityPresetRestricted, []string{"NET_ADMIN"}))
+
+	podManifest = newManifest()
+	require.NoError(t, applySecurityPreset(podManifest, SecurityPresetBaseline, nil))
+	require.Nil(t, podManifest.Spec.SecurityContext)
+	require.Nil(t, podManifest.Spec.Containers[0].SecurityContext)
+	require.Error(t, applySecurityPreset(newManifest(), SecurityPresetBaseline, []string{"SYS_PTRACE"}))
+
+	podManifest = newManifest()
+	require.NoError(t, applySecurityPreset(podManifest, SecurityPresetPrivileged, []string{"net_admin", "CAP_SYS_PTRACE"}))
+	require.Equal(t, []string{"NET_ADMIN", "SYS_PTRACE"}, podManifest.Spec.Containers[0].SecurityContext.Capabilities.Add)
+
+	require.Error(t, applySecurityPreset(newManifest(), "yolo", nil))
+}
+
+func TestMergeWorkloadIntoPodManifest(t *testing.T) {
+	podManifest, err := MakePodManifestFromTemplate("somedude42", "testpod-foo", nil, NewDefaultTemplate())
+	require.NoError(t, err)
+
+	var workload WorkloadPodTemplate
+	workload.Labels = map[string]string{"app": "foo", "app.kubernetes.io/name": "foo"}
+	workload.Spec.ServiceAccountName = "foo-sa"
+	workload.Spec.NodeSelector = map[string]string{"pool": "apps"}
+	workload.Spec.Volumes = []interface{}{map[string]interface{}{"name": "data"}}
+	workload.Spec.Containers = []WorkloadContainer{
+		{Name: "app", Env: []interface{}{map[string]interface{}{"name": "FOO", "value": "bar"}}},
+	}
+
+	aspects, err := SelectLikeAspects(nil, []string{LikeAspectVolumes})
+	require.NoError(t, err)
+	skipped := MergeWorkloadIntoPodManifest(&podManifest, workload, aspects)
+	require.Equal(t, []string{"app.kubernetes.io/name"}, skipped)
+	require.Equal(t, "foo", podManifest.Metadata.Labels["app"])
+	require.Equal(t, "go-testpod", podManifest.Metadata.Labels["app.kubernetes.io/name"])
+	require.Equal(t, "foo-sa", podManifest.Spec.ServiceAccountName)
+	require.Equal(t, map[string]string{"pool": "apps"}, podManifest.Spec.NodeSelector)
+	require.Len(t, podManifest.Spec.Containers[0].Env, 1)
+	require.Empty(t, podManifest.Spec.Volumes)
+	require.Equal(t, []string{"sleep"}, podManifest.Spec.Containers[0].Command)
+
+	require.True(t, MatchesSelector(map[string]string{"app": "foo"}, podManifest.Metadata.Labels))
+	require.False(t, MatchesSelector(map[string]string{}, podManifest.Metadata.Labels))
+
+	_, err = SelectLikeAspects([]string{"everything"}, nil)
+	require.Error(t, err)
+}
+
+func TestMergeWorkloadServiceAccountIntoPodManifest(t *testing.T) {
+	tpl := NewDefaultTemplate()
+	tpl.Pod.ServiceAccountName = "debug-sa"
+	aspects, err := SelectLikeAspects(nil, nil)
+	require.NoError(t, err)
+
+	var workload WorkloadPodTemplate
+	podManifest, err := MakePodManifestFromTemplate("somedude42", "testpod-foo", nil, tpl)
+	require.NoError(t, err)
+	MergeWorkloadIntoPodManifest(&podManifest, workload, aspects)
+	require.Equal(t, "debug-sa", podManifest.Spec.ServiceAccountName)
+
+	workload.Spec.ServiceAccountName = "foo-sa"
+	MergeWorkloadIntoPodManifest(&podManifest, workload, aspects)
+	require.Equal(t, "debug-sa", podManifest.Spec.ServiceAccountName)
+}
+
+func TestMakeCloneManifest(t *testing.T) {
+	pod := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "app-123", "labels": map[string]interface{}{"app": "foo"}},
+		"spec": map[string]interface{}{
+			"nodeName": "node-1",
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "foo:1", "command": []interface{}{"/app"}, "livenessProbe": map[string]interface{}{}},
+				map[string]interface{}{"name": "sidecar", "image": "bar:1", "readinessProbe": map[string]interface{}{}},
+			},
+		},
+	}
+
+	clone, err := MakeCloneManifest("somedude42", "testpod-foo", pod, "", NewDefaultTemplate())
+	require.NoError(t, err)
+	metadata := clone["metadata"].(map[string]interface{})
+	require.Equal(t, "testpod-foo", metadata["name"])
+	require.Equal(t, "go-testpod", metadata["labels"].(map[string]string)["app.kubernetes.io/name"])
+	require.NotContains(t, metadata["labels"], "app")
+	spec := clone["spec"].(map[string]interface{})
+	require.NotContains(t, spec, "nodeName")
+	containers := spec["containers"].([]interface{})
+	app := containers[0].(map[string]interface{})
+	require.Equal(t, []string{"sleep"}, app["command"])
+	require.NotContains(t, app, "livenessProbe")
+	sidecar := containers[1].(map[string]interface{})
+	require.NotContains(t, sidecar, "command")
+	require.NotContains(t, sidecar, "readinessProbe")
+	// original pod is left untouched
+	require.Contains(t, pod["spec"].(map[string]interface{}), "nodeName")
+
+	_, err = MakeCloneManifest("somedude42", "testpod-foo", pod, "missing", NewDefaultTemplate())
+	require.Error(t, err)
+}
+
+func TestMakeJobManifestFromTemplate(t *testing.T) {
+	tpl := NewDefaultTemplate()
+	tpl.Pod.AdditionalLabels["team"] = "a"
+	job, err := MakeJobManifestFromTemplate("somedude42", "testpod-foo", nil, tpl, []string{"nslookup", "kubernetes"}, 10*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "Job", job.Kind)
+	require.Equal(t, "testpod-foo", job.Metadata.Name)
+	require.Equal(t, 0, job.Spec.BackoffLimit)
+	require.Equal(t, 600, job.Spec.TTLSecondsAfterFinished)
+	require.Equal(t, "Never", job.Spec.Template.Spec.RestartPolicy)
+	require.Empty(t, job.Spec.Template.Metadata.Name)
+	// pods of the job need the labels selected by the NetworkPolicy
+	for k, v := range MakeMatchLabels("somedude42", "testpod-foo") {
+		require.Equal(t, v, job.Spec.Template.Metadata.Labels[k])
+	}
+	require.Equal(t, "a", job.Spec.Template.Metadata.Labels["team"])
+	require.Equal(t, []string{"nslookup", "kubernetes"}, job.Spec.Template.Spec.Containers[0].Command)
+	require.Nil(t, job.Spec.Template.Spec.Containers[0].Args)
+
+	_, err = MakeJobManifestFromTemplate("somedude42", "testpod-foo", nil, tpl, nil, time.Minute)
+	require.Error(t, err)
+}
+
+func TestMakeNetworkPolicyManifestFromTemplate(t *testing.T) {
+	tpl := NewDefaultTemplate()
+	nwPol, err := MakeNetworkPolicyManifestFromTemplate("somedude42", "testpod-foo", tpl)
+	require.NoError(t, err)
+	require.Nil(t, nwPol)
+
+	tpl.NetworkPolicy.CreateAllowAll = true
+	nwPol, err = MakeNetworkPolicyManifestFromTemplate("somedude42", "testpod-foo", tpl)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Egress"}, nwPol.Spec.PolicyTypes)
+	require.Equal(t, []EgressBlock{{Ports: []PortBlock{{Protocol: "TCP", Port: 1, EndPort: 65535}}, To: []PeerBlock{}}}, nwPol.Spec.Egress)
+
+	tpl.NetworkPolicy.CreateAllowAll = false
+	tpl.NetworkPolicy.Presets = []string{NetworkPolicyPresetDNSOnly, NetworkPolicyPresetIngressFromNamespace}
+	tpl.NetworkPolicy.Egress = []NetworkPolicyRuleTemplate{
+		{Ports: []NetworkPolicyPortTemplate{{Protocol: "udp", Port: 123}}, CIDRs: []string{"10.0.0.0/8"}},
+		{NamespaceSelector: map[string]string{"team": "a"}, PodSelector: map[string]string{"app": "db"}},
+	}
+	nwPol, err = MakeNetworkPolicyManifestFromTemplate("somedude42", "testpod-foo", tpl)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Ingress", "Egress"}, nwPol.Spec.PolicyTypes)
+	require.Len(t, nwPol.Spec.Egress, 3)
+	require.Equal(t, []PortBlock{{Protocol: "UDP", Port: 123}}, nwPol.Spec.Egress[0].Ports)
+	require.Equal(t, "10.0.0.0/8", nwPol.Spec.Egress[0].To[0].IPBlock.CIDR)
+	require.Equal(t, map[string]string{"team": "a"}, nwPol.Spec.Egress[1].To[0].NamespaceSelector.MatchLabels)
+	require.Equal(t, map[string]string{"app": "db"}, nwPol.Spec.Egress[1].To[0].PodSelector.MatchLabels)
+	require.Equal(t, []PortBlock{{Protocol: "UDP", Port: 53}, {Protocol: "TCP", Port: 53}}, nwPol.Spec.Egress[2].Ports)
+	require.Equal(t, []PeerBlock{{PodSelector: &LabelSelectorBlock{MatchLabels: map[string]string{}}}}, nwPol.Spec.Ingress[0].From)
+
+	tpl.NetworkPolicy.Presets = []string{"allow-everything"}
+	_, err = MakeNetworkPolicyManifestFromTemplate("somedude42", "testpod-foo", tpl)
+	require.Error(t, err)
+	tpl.NetworkPolicy.Presets = nil
+	tpl.NetworkPolicy.Egress = []NetworkPolicyRuleTemplate{{Ports: []NetworkPolicyPortTemplate{{Protocol: "ICMP"}}}}
+	_, err = MakeNetworkPolicyManifestFromTemplate("somedude42", "testpod-foo", tpl)
+	require.Error(t, err)
+}
+
+func TestMakePodManifestWithAgent(t *testing.T) {
+	tpl := NewDefaultTemplate()
+	tpl.Pod.Security = SecurityPresetRestricted
+	tpl.Pod.AgentBinary = "/usr/local/bin/testpod"
+	podManifest, err := MakePodManifestFromTemplate("dev", "testpod-dev", nil, tpl)
+	require.NoError(t, err)
+
+	require.Len(t, podManifest.Spec.InitContainers, 1)
+	initContainer := podManifest.Spec.InitContainers[0]
+	require.Equal(t, AgentContainerName, initContainer.Name)
+	require.Equal(t, DefaultAgentInitImage, initContainer.Image)
+	require.True(t, initContainer.Stdin)
+	require.True(t, initContainer.StdinOnce)
+	require.Equal(t, []string{"ALL"}, initContainer.SecurityContext.Capabilities.Drop)
+	require.Len(t, podManifest.Spec.Volumes, 1)
+	require.Equal(t, initContainer.VolumeMounts, podManifest.Spec.Containers[0].VolumeMounts)
+	require.Equal(t, "main", podManifest.Metadata.Annotations["kubectl.kubernetes.io/default-container"])
+
+	podManifest.Metadata.SetAnnotation(ReasonAnnotation, "debugging")
+	require.Equal(t, "main", podManifest.Metadata.Annotations["kubectl.kubernetes.io/default-container"])
+	require.Equal(t, "debugging", podManifest.Metadata.Annotations[ReasonAnnotation])
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type KubeconfigFile struct {
+	Path string
+	Data []byte
+}
+
+func getKubeconfigPaths() ([]string, error) {
+	paths := make([]string, 0)
+	for _, path := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		// kubectl silently ignores missing files in the list
+		if fileExists(path) {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		return paths, nil
+	}
+
+	userHomeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("get user home dir: %w", err)
+	}
+	path := filepath.Join(userHomeDir, ".kube", "config")
+	if !fileExists(path) {
+		return nil, fmt.Errorf("no local kubeconfig file found. try using the --no-temp-kubeconfig flag")
+	}
+	return []string{path}, nil
+}
+
+// merge like kubectl does, so the first file to define an entry wins. relative paths are resolved as the merged file is stored elsewhere
+func MergeKubeconfigs(files []KubeconfigFile) ([]byte, error) {
+	merged := map[string]interface{}{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"preferences":     map[string]interface{}{},
+		"current-context": "",
+	}
+	seen := make(map[string]map[string]bool)
+	for _, section := range []string{"clusters", "users", "contexts"} {
+		merged[section] = []interface{}{}
+		seen[section] = make(map[string]bool)
+	}
+
+	for _, f := range files {
+		var config map[string]interface{}
+		if err := yaml.Unmarshal(f.Data, &config); err != nil {
+			return nil, fmt.Errorf("parse kubeconfig %q: %w", f.Path, err)
+		}
+		absPath, err := filepath.Abs(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("resolve path of kubeconfig %q: %w", f.Path, err)
+		}
+		dir := filepath.Dir(absPath)
+
+		if currentContext, ok := config["current-context"].(string); ok && len(currentContext) > 0 && merged["current-context"] == "" {
+			merged["current-context"] = currentContext
+		}
+		for _, section := range []string{"clusters", "users", "contexts"} {
+			items, _ := config[section].([]interface{})
+			for _, item := range items {
+				entry, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				name, _ := entry["name"].(string)
+				if seen[section][name] {
+					continue
+				}
+				seen[section][name] = true
+
+				switch section {
+				case "clusters":
+					resolveKubeconfigPaths(entry["cluster"], dir, "certificate-authority")
+				case "users":
+					resolveKubeconfigPaths(entry["user"], dir, "client-certificate", "client-key", "tokenFile")
+					if user, ok := entry["user"].(map[string]interface{}); ok {
+						if execConfig, ok := user["exec"].(map[string]interface{}); ok {
+							// commands without path separator are looked up in PATH and must not be changed
+							if command, ok := execConfig["command"].(string); ok && strings.ContainsRune(command, filepath.Separator) {
+								resolveKubeconfigPaths(execConfig, dir, "command")
+							}
+						}
+					}
+				}
+				merged[section] = append(merged[section].([]interface{}), entry)
+			}
+		}
+	}
+
+	return yaml.Marshal(merged)
+}
+
+func resolveKubeconfigPaths(obj interface{}, dir string, keys ...string) {
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, key := range keys {
+		if path, ok := m[key].(string); ok && len(path) > 0 && !filepath.IsAbs(path) {
+			m[key] = filepath.Join(dir, path)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeKubeconfigs(t *testing.T) {
+	data, err := MergeKubeconfigs([]KubeconfigFile{
+		{Path: "/home/dude/.kube/config", Data: []byte(`
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+    certificate-authority: certs/dev-ca.crt
+users:
+- name: dev
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: ./bin/login-helper
+- name: plugin
+  user:
+    exec:
+      command: kubelogin
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+`)},
+		{Path: "/etc/kube/prod.yaml", Data: []byte(`
+current-context: prod
+clusters:
+- name: dev
+  cluster:
+    server: https://shadowed.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+    certificate-authority: /etc/kube/prod-ca.crt
+users:
+- name: prod
+  user:
+    client-certificate: prod.crt
+    client-key: prod.key
+contexts:
+- name: prod
+  context: {cluster: prod, user: prod}
+`)},
+	})
+	require.NoError(t, err)
+
+	var merged struct {
+		CurrentContext string `yaml:"current-context"`
+		Clusters       []struct {
+			Name    string `yaml:"name"`
+			Cluster struct {
+				Server               string `yaml:"server"`
+				CertificateAuthority string `yaml:"certificate-authority"`
+			} `yaml:"cluster"`
+		} `yaml:"clusters"`
+		Users []struct {
+			Name string `yaml:"name"`
+			User struct {
+				ClientCertificate string `yaml:"client-certificate"`
+				ClientKey         string `yaml:"client-key"`
+				Exec              struct {
+					Command string `yaml:"command"`
+				} `yaml:"exec"`
+			} `yaml:"user"`
+		} `yaml:"users"`
+		Contexts []struct {
+			Name string `yaml:"name"`
+		} `yaml:"contexts"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &merged))
+
+	require.Equal(t, "dev", merged.CurrentContext)
+	require.Len(t, merged.Clusters, 2)
+	require.Equal(t, "https://dev.example.com", merged.Clusters[0].Cluster.Server)
+	require.Equal(t, "/home/dude/.kube/certs/dev-ca.crt", merged.Clusters[0].Cluster.CertificateAuthority)
+	require.Equal(t, "/etc/kube/prod-ca.crt", merged.Clusters[1].Cluster.CertificateAuthority)
+	require.Len(t, merged.Users, 3)
+	require.Equal(t, "/home/dude/.kube/bin/login-helper", merged.Users[0].User.Exec.Command)
+	require.Equal(t, "kubelogin", merged.Users[1].User.Exec.Command)
+	require.Equal(t, "/etc/kube/prod.crt", merged.Users[2].User.ClientCertificate)
+	require.Equal(t, "/etc/kube/prod.key", merged.Users[2].User.ClientKey)
+	require.Len(t, merged.Contexts, 2)
+}
@@ -1,60 +1,112 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	tempKubeconfigPath string
+	kubectlNamespace   string
+	kubectlContext     string
 )
 
-func withKubeConfig(noTempKubeConfig bool, f func() error) error {
+func withKubeConfig(noTempKubeConfig bool, f func() error) (resultErr error) {
 	if !noTempKubeConfig {
-		realKubeconfigPath := os.Getenv("KUBECONFIG")
-		if !fileExists(realKubeconfigPath) {
-			userHomeDir, err := os.UserHomeDir()
+		realKubeconfigPaths, err := getKubeconfigPaths()
+		if err != nil {
+			return err
+		}
+		files := make([]KubeconfigFile, 0, len(realKubeconfigPaths))
+		for _, path := range realKubeconfigPaths {
+			data, err := os.ReadFile(path)
 			if err != nil {
-				return fmt.Errorf("get user home dir: %w", err)
-			}
-			realKubeconfigPath = filepath.Join(userHomeDir, ".kube", "config")
-
-			if !fileExists(realKubeconfigPath) {
-				return fmt.Errorf("no local kubeconfig file found. try using the --no-temp-kubeconfig flag")
+				return fmt.Errorf("read kubeconfig: %w", err)
 			}
+			files = append(files, KubeconfigFile{Path: path, Data: data})
 		}
-
-		tmpFile, err := os.CreateTemp(os.TempDir(), "testpod-kubeconfig-*.yaml")
+		data, err := MergeKubeconfigs(files)
 		if err != nil {
-			return fmt.Errorf("create temp kubeconfig: %w", err)
+			return fmt.Errorf("merge kubeconfig: %w", err)
 		}
-		tmpFile.Close()
-		tempKubeconfigPath = tmpFile.Name()
-		fmt.Println("clone kubeconfig", realKubeconfigPath, "to", tempKubeconfigPath)
-		data, err := os.ReadFile(realKubeconfigPath)
+
+		// the kubeconfig contains credentials, so keep it private to the current user
+		tmpDir, err := os.MkdirTemp(os.TempDir(), "testpod-kubeconfig-*")
 		if err != nil {
-			return fmt.Errorf("read kubeconfig: %w", err)
+			return fmt.Errorf("create temp kubeconfig dir: %w", err)
 		}
-		if err := os.WriteFile(tempKubeconfigPath, data, os.ModePerm); err != nil {
+		tempKubeconfigPath = filepath.Join(tmpDir, "config.yaml")
+		fmt.Fprintln(infoOut, "clone kubeconfig", strings.Join(realKubeconfigPaths, string(filepath.ListSeparator)), "to", tempKubeconfigPath)
+		if err := os.WriteFile(tempKubeconfigPath, data, 0600); err != nil {
+			os.RemoveAll(tmpDir)
 			return fmt.Errorf("write temp kubeconfig: %w", err)
 		}
 		defer func() {
-			if err := os.Remove(tempKubeconfigPath); err != nil {
-				fmt.Println("WARN: failed to delete temp kubeconfig file", tempKubeconfigPath+":", err)
+			if removeErr := os.RemoveAll(tmpDir); removeErr != nil {
+				fmt.Fprintln(infoOut, "WARN: failed to delete temp kubeconfig file", tempKubeconfigPath+":", removeErr)
+				if resultErr == nil {
+					resultErr = cleanupWarning(fmt.Errorf("delete temp kubeconfig: %w", removeErr))
+				}
 			} else {
-				fmt.Println("temp kubeconfig file", tempKubeconfigPath, "deleted")
+				fmt.Fprintln(infoOut, "temp kubeconfig file", tempKubeconfigPath, "deleted")
 			}
 		}()
+
+		if len(kubectlContext) > 0 {
+			if err := kubectl(options{
+				Args:   []string{"config", "use-context", kubectlContext},
+				Silent: true,
+			}); err != nil {
+				return fmt.Errorf("switch to context %q in temp kubeconfig: %w", kubectlContext, err)
+			}
+			if len(kubectlNamespace) > 0 {
+				if err := kubectl(options{
+					Args:   []string{"config", "set-context", "--current", "--namespace=" + kubectlNamespace},
+					Silent: true,
+				}); err != nil {
+					return fmt.Errorf("set namespace in temp kubeconfig: %w", err)
+				}
+			}
+		}
 	}
 
 	return f()
 }
 
+func validateKubectlContext(contextName string) error {
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"config", "get-contexts", "-o", "name"},
+		Silent: true,
+	})
+	if err != nil {
+		return fmt.Errorf("get contexts: %w", err)
+	}
+	contexts := strings.Fields(out)
+	for _, c := range contexts {
+		if c == contextName {
+			return nil
+		}
+	}
+
+	similar := FindSimilarNames(contextName, contexts)
+	if len(similar) > 0 {
+		return usageErrorf("context %q does not exist, did you mean %s?", contextName, strings.Join(similar, ", "))
+	}
+	return usageErrorf("context %q does not exist", contextName)
+}
+
 func fileExists(path string) bool {
 	if len(path) == 0 {
 		return false
@@ -100,6 +152,230 @@ func kubectlGetPodNames(matchLabels map[string]string) ([]string, error) {
 	return podNames, nil
 }
 
+type DebugContainer struct {
+	PodName       string
+	ContainerName string
+	TargetName    string
+	State         string
+}
+
+func kubectlGetDebugContainers() ([]DebugContainer, error) {
+	var obj struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				EphemeralContainers []struct {
+					Name                string `json:"name"`
+					TargetContainerName string `json:"targetContainerName"`
+				} `json:"ephemeralContainers"`
+			} `json:"spec"`
+			Status struct {
+				EphemeralContainerStatuses []struct {
+					Name  string                     `json:"name"`
+					State map[string]json.RawMessage `json:"state"`
+				} `json:"ephemeralContainerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+
+	args := []string{"get", "pods", "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+
+	debugContainers := make([]DebugContainer, 0)
+	for _, item := range obj.Items {
+		for _, c := range item.Spec.EphemeralContainers {
+			if !strings.HasPrefix(c.Name, "testpod-") {
+				continue
+			}
+			state := "unknown"
+			for _, status := range item.Status.EphemeralContainerStatuses {
+				if status.Name == c.Name {
+					for k := range status.State {
+						state = k
+					}
+				}
+			}
+			debugContainers = append(debugContainers, DebugContainer{
+				PodName:       item.Metadata.Name,
+				ContainerName: c.Name,
+				TargetName:    c.TargetContainerName,
+				State:         state,
+			})
+		}
+	}
+	return debugContainers, nil
+}
+
+func kubectlGetContainerNames(podName string) ([]string, error) {
+	var obj struct {
+		Spec struct {
+			Containers []struct {
+				Name string `json:"name"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+
+	args := []string{"get", "pod", podName, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+
+	containerNames := make([]string, 0, len(obj.Spec.Containers))
+	for _, c := range obj.Spec.Containers {
+		containerNames = append(containerNames, c.Name)
+	}
+	return containerNames, nil
+}
+
+func kubectlGetWorkloadPodTemplate(ref string) (WorkloadPodTemplate, error) {
+	var obj struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec json.RawMessage `json:"spec"`
+	}
+
+	args := []string{"get", ref, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return WorkloadPodTemplate{}, err
+	}
+
+	var podTemplate struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec json.RawMessage `json:"spec"`
+	}
+	switch obj.Kind {
+	case "Pod":
+		podTemplate.Metadata.Labels = obj.Metadata.Labels
+		podTemplate.Spec = obj.Spec
+
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		var spec struct {
+			Template json.RawMessage `json:"template"`
+		}
+		if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+			return WorkloadPodTemplate{}, fmt.Errorf("parse %s spec: %w", obj.Kind, err)
+		}
+		if err := json.Unmarshal(spec.Template, &podTemplate); err != nil {
+			return WorkloadPodTemplate{}, fmt.Errorf("parse pod template: %w", err)
+		}
+
+	case "CronJob":
+		var spec struct {
+			JobTemplate struct {
+				Spec struct {
+					Template json.RawMessage `json:"template"`
+				} `json:"spec"`
+			} `json:"jobTemplate"`
+		}
+		if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+			return WorkloadPodTemplate{}, fmt.Errorf("parse %s spec: %w", obj.Kind, err)
+		}
+		if err := json.Unmarshal(spec.JobTemplate.Spec.Template, &podTemplate); err != nil {
+			return WorkloadPodTemplate{}, fmt.Errorf("parse pod template: %w", err)
+		}
+
+	default:
+		return WorkloadPodTemplate{}, fmt.Errorf("unsupported kind %q", obj.Kind)
+	}
+
+	var workload WorkloadPodTemplate
+	workload.Labels = podTemplate.Metadata.Labels
+	if err := json.Unmarshal(podTemplate.Spec, &workload.Spec); err != nil {
+		return WorkloadPodTemplate{}, fmt.Errorf("parse pod spec: %w", err)
+	}
+	return workload, nil
+}
+
+func kubectlGetServiceSelectors() (map[string]map[string]string, error) {
+	var obj struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Selector map[string]string `json:"selector"`
+			} `json:"spec"`
+		} `json:"items"`
+	}
+
+	args := []string{"get", "services", "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+
+	selectors := make(map[string]map[string]string)
+	for _, item := range obj.Items {
+		selectors[item.Metadata.Name] = item.Spec.Selector
+	}
+	return selectors, nil
+}
+
+func kubectlGetPod(podName string) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	args := []string{"get", "pod", podName, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func kubectlGetPodImageAndNode(podName string) (string, string, error) {
+	var obj struct {
+		Spec struct {
+			NodeName   string `json:"nodeName"`
+			Containers []struct {
+				Image string `json:"image"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+	if err := kubectl(options{
+		Args:      []string{"get", "pod", podName, "-o", "json"},
+		Silent:    true,
+		ParseJSON: &obj,
+	}); err != nil {
+		return "", "", err
+	}
+	var image string
+	if len(obj.Spec.Containers) > 0 {
+		image = obj.Spec.Containers[0].Image
+	}
+	return image, obj.Spec.NodeName, nil
+}
+
+func kubectlGetPodIP(podName string) (string, error) {
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"get", "pod", podName, "-o", "jsonpath={.status.podIP}"},
+		Silent: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return strings.TrimSpace(out), nil
+}
+
 func kubectlGetWorkerNodes() ([]Node, error) {
 	var obj struct {
 		Items []struct {
@@ -109,7 +385,9 @@ func kubectlGetWorkerNodes() ([]Node, error) {
 			} `json:"metadata"`
 			Spec struct {
 				Taints []struct {
-					Key string `json:"key"`
+					Key    string `json:"key"`
+					Value  string `json:"value"`
+					Effect string `json:"effect"`
 				} `json:"taints"`
 			} `json:"spec"`
 			Status struct {
@@ -138,10 +416,15 @@ func kubectlGetWorkerNodes() ([]Node, error) {
 			}
 		}
 		if !isControlPlane {
+			taints := make([]Taint, 0, len(node.Spec.Taints))
+			for _, t := range node.Spec.Taints {
+				taints = append(taints, Taint{Key: t.Key, Value: t.Value, Effect: t.Effect})
+			}
 			nodes = append(nodes, Node{
 				Name:    node.Metadata.Name,
 				Age:     time.Since(node.Metadata.CreationTimestamp),
 				Version: node.Status.NodeInfo.KubeletVersion,
+				Taints:  taints,
 			})
 		}
 	}
@@ -172,43 +455,686 @@ func kubectlGetNodeLabels(nodeName string, ignoredLabels map[string]bool) (map[s
 	return nodeLabels, nil
 }
 
+type QuotaError struct {
+	QuotaName string
+	Message   string
+	Err       error
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("blocked by quota %q: %s", e.QuotaName, e.Message)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	exceededQuotaPattern = regexp.MustCompile(`exceeded quota: ([^,\s]+), (requested: .*)`)
+	failedQuotaPattern   = regexp.MustCompile(`failed quota: ([^:\s]+): (.*)`)
+)
+
+func kubectlGetCurrentContext() (string, error) {
+	if len(kubectlContext) > 0 {
+		return kubectlContext, nil
+	}
+
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"config", "current-context"},
+		Silent: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func kubectlGetCurrentNamespace() (string, error) {
+	if len(kubectlNamespace) > 0 {
+		return kubectlNamespace, nil
+	}
+
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"config", "view", "--minify", "-o", "jsonpath={..namespace}"},
+		Silent: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	namespace := strings.TrimSpace(out)
+	if len(namespace) == 0 {
+		return "default", nil
+	}
+	return namespace, nil
+}
+
+func kubectlGetNamespaceLabels(namespace string) (map[string]string, error) {
+	var obj struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+
+	args := []string{"get", "namespace", namespace, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+	return obj.Metadata.Labels, nil
+}
+
+func kubectlGetNodeTaints(nodeName string) ([]Taint, error) {
+	var obj struct {
+		Spec struct {
+			Taints []struct {
+				Key    string `json:"key"`
+				Value  string `json:"value"`
+				Effect string `json:"effect"`
+			} `json:"taints"`
+		} `json:"spec"`
+	}
+
+	args := []string{"get", "node", nodeName, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+
+	taints := make([]Taint, 0, len(obj.Spec.Taints))
+	for _, t := range obj.Spec.Taints {
+		taints = append(taints, Taint{Key: t.Key, Value: t.Value, Effect: t.Effect})
+	}
+	return taints, nil
+}
+
 func kubectlApply(manifestData string) error {
-	return kubectl(options{
+	out, err := kubectlGetOutput(options{
 		Args:  []string{"apply", "-f", "-"},
 		StdIn: manifestData,
 	})
+	if err != nil {
+		if m := exceededQuotaPattern.FindStringSubmatch(out); m != nil {
+			return &QuotaError{QuotaName: m[1], Message: strings.TrimSpace(m[2]), Err: err}
+		}
+		if m := failedQuotaPattern.FindStringSubmatch(out); m != nil {
+			return &QuotaError{QuotaName: m[1], Message: strings.TrimSpace(m[2]), Err: err}
+		}
+	}
+	return err
+}
+
+type ResourceQuotaUsage struct {
+	Resource string
+	Hard     string
+	Used     string
+}
+
+func kubectlGetResourceQuotaUsage(quotaName string) ([]ResourceQuotaUsage, error) {
+	var obj struct {
+		Status struct {
+			Hard map[string]string `json:"hard"`
+			Used map[string]string `json:"used"`
+		} `json:"status"`
+	}
+
+	args := []string{"get", "resourcequota", quotaName, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+
+	usage := make([]ResourceQuotaUsage, 0, len(obj.Status.Hard))
+	for resource, hard := range obj.Status.Hard {
+		usage = append(usage, ResourceQuotaUsage{
+			Resource: resource,
+			Hard:     hard,
+			Used:     obj.Status.Used[resource],
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Resource < usage[j].Resource })
+	return usage, nil
 }
 
+// kubectlWaitForPod only reports scheduling timeouts if kubectl timed out. other failures like denied requests remain cluster errors
 func kubectlWaitForPod(podName string) error {
-	return kubectl(options{
+	out, err := kubectlGetOutput(options{
 		Args: []string{"wait", "--for=condition=ready", "--timeout=30s", "pod/" + podName},
 	})
+	if err != nil && strings.Contains(out, "timed out waiting") {
+		return schedulingTimeoutError(err)
+	}
+	return err
 }
 
-func kubectlExec(podName string, shell string) error {
+type RemoteExitError struct {
+	ExitCode int
+}
+
+func (e *RemoteExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", e.ExitCode)
+}
+
+// kubectlExec streams stdin to the command and only allocates a tty if stdin and stdout are terminals
+func kubectlExec(podName string, command ...string) error {
+	args := []string{"exec", "-i"}
+	if IsTerminal(os.Stdin) && IsTerminal(os.Stdout) {
+		args = append(args, "-t")
+	}
+	args = append(args, podName, "--")
+	stderr := &tailBuffer{Size: 4096}
+	err := kubectl(options{
+		Args:          append(args, command...),
+		PipeAll:       true,
+		StderrCapture: stderr,
+	})
+	if err != nil {
+		// kubectl uses the same exit code for failed remote commands, but only reports them with this message
+		if m := remoteExitCodePattern.FindSubmatch(stderr.Data); m != nil {
+			if exitCode, convErr := strconv.Atoi(string(m[1])); convErr == nil {
+				return &RemoteExitError{ExitCode: exitCode}
+			}
+		}
+	}
+	return err
+}
+
+var remoteExitCodePattern = regexp.MustCompile(`command terminated with exit code ([0-9]+)\s*$`)
+
+// tailBuffer only keeps the last Size bytes written to it
+type tailBuffer struct {
+	Size int
+	Data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.Data = append(b.Data, p...)
+	if len(b.Data) > b.Size {
+		b.Data = b.Data[len(b.Data)-b.Size:]
+	}
+	return len(p), nil
+}
+
+func kubectlDebug(podName, containerName, targetName, image, profile string, command ...string) error {
+	args := []string{"debug", podName, "-it", "--image=" + image, "--container=" + containerName, "--target=" + targetName}
+	if len(profile) > 0 {
+		args = append(args, "--profile="+profile)
+	}
 	return kubectl(options{
-		Args:    []string{"exec", "-it", podName, "--", shell},
+		Args:    append(append(args, "--"), command...),
 		PipeAll: true,
 	})
 }
 
+func kubectlAnnotatePod(podName, key, value string) error {
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"annotate", "pod", podName, "--overwrite", key + "=" + value},
+		Silent: true,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// kubectlDebugDetached adds an ephemeral container without attaching to it
+func kubectlDebugDetached(podName, containerName, image, profile string, command ...string) error {
+	args := []string{"debug", podName, "--image=" + image, "--container=" + containerName}
+	if len(profile) > 0 {
+		args = append(args, "--profile="+profile)
+	}
+	out, err := kubectlGetOutput(options{
+		Args:   append(append(args, "--"), command...),
+		Silent: true,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+// kubectlExecStream writes stdout of the command to w and passes stderr through to the user
+func kubectlExecStream(podName, containerName string, w io.Writer, command ...string) error {
+	args := []string{"exec", podName}
+	if len(containerName) > 0 {
+		args = append(args, "-c", containerName)
+	}
+	_, err := kubectlGetOutput(options{
+		Args:          append(append(args, "--"), command...),
+		Stdout:        w,
+		StderrCapture: os.Stderr,
+	})
+	return err
+}
+
+func kubectlExecGetOutput(podName string, command ...string) (string, error) {
+	return kubectlGetOutput(options{
+		Args:   append([]string{"exec", podName, "--"}, command...),
+		Silent: true,
+	})
+}
+
+func kubectlExecContainerGetOutput(podName, containerName string, command ...string) (string, error) {
+	return kubectlGetOutput(options{
+		Args:   append([]string{"exec", podName, "-c", containerName, "--"}, command...),
+		Silent: true,
+	})
+}
+
+// kubectlExecGetStdout keeps messages of kubectl and the remote command out of the output, e.g. for parsing json. stderr is added to the error instead
+func kubectlExecGetStdout(podName string, command ...string) (string, error) {
+	var stdout bytes.Buffer
+	stderr, err := kubectlGetOutput(options{
+		Args:   append([]string{"exec", podName, "--"}, command...),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		return stdout.String(), fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+	}
+	return stdout.String(), nil
+}
+
+// kubectlExecGetOutputAndExitCode separates failed remote commands from failed kubectl calls
+func kubectlExecGetOutputAndExitCode(podName string, command ...string) (string, int, error) {
+	out, err := kubectlExecGetOutput(podName, command...)
+	if err != nil {
+		if m := remoteExitCodePattern.FindStringSubmatchIndex(out); m != nil {
+			if exitCode, convErr := strconv.Atoi(out[m[2]:m[3]]); convErr == nil {
+				return out[:m[0]], exitCode, nil
+			}
+		}
+		return out, 0, err
+	}
+	return out, 0, nil
+}
+
+// kubectlAuthCanIList passes warnings on stderr through to the user and only returns the list
+func kubectlAuthCanIList(asUser string) (string, error) {
+	var stdout bytes.Buffer
+	_, err := kubectlGetOutput(options{
+		Args:          []string{"auth", "can-i", "--list", "--as=" + asUser},
+		Stdout:        &stdout,
+		StderrCapture: os.Stderr,
+	})
+	return stdout.String(), err
+}
+
 func kubectlDeletePod(podName string) error {
 	return kubectl(options{
 		Args: []string{"delete", "--wait=false", "pod", podName},
 	})
 }
 
+func kubectlWaitForServiceAccount(name string) error {
+	// service accounts of new namespaces are created asynchronously and pods are rejected until then
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		out, err := kubectlGetOutput(options{
+			Args:   []string{"get", "serviceaccount", name, "-o", "name"},
+			Silent: true,
+		})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func kubectlDeleteJob(name string) error {
+	// background propagation also deletes the pods of the job
+	return kubectl(options{
+		Args: []string{"delete", "--wait=false", "--cascade=background", "--ignore-not-found", "job", name},
+	})
+}
+
+type JobPodStatus struct {
+	PodName string
+	Phase   string
+	// WaitingReason is set while the container is not started yet, e.g. ErrImagePull
+	WaitingReason string
+	// ExitCode is set as soon as the container is terminated
+	ExitCode *int
+}
+
+func kubectlGetJobPodStatus(jobName string) (*JobPodStatus, error) {
+	var obj struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase             string `json:"phase"`
+				ContainerStatuses []struct {
+					State struct {
+						Waiting *struct {
+							Reason string `json:"reason"`
+						} `json:"waiting"`
+						Terminated *struct {
+							ExitCode int `json:"exitCode"`
+						} `json:"terminated"`
+					} `json:"state"`
+				} `json:"containerStatuses"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := kubectl(options{
+		Args:      []string{"get", "pods", "-l", "job-name=" + jobName, "-o", "json"},
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+	if len(obj.Items) == 0 {
+		return nil, nil
+	}
+
+	item := obj.Items[0]
+	status := &JobPodStatus{PodName: item.Metadata.Name, Phase: item.Status.Phase}
+	if len(item.Status.ContainerStatuses) > 0 {
+		state := item.Status.ContainerStatuses[0].State
+		if state.Waiting != nil {
+			status.WaitingReason = state.Waiting.Reason
+		}
+		if state.Terminated != nil {
+			status.ExitCode = &state.Terminated.ExitCode
+		}
+	}
+	return status, nil
+}
+
+func kubectlWaitForJobPod(jobName string, timeout time.Duration) (*JobPodStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := kubectlGetJobPodStatus(jobName)
+		if err != nil {
+			return nil, err
+		}
+		if status != nil {
+			switch status.WaitingReason {
+			case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError":
+				return nil, fmt.Errorf("pod %s cannot start: %s", status.PodName, status.WaitingReason)
+			}
+			if status.Phase != "Pending" {
+				return status, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, schedulingTimeoutError(fmt.Errorf("pod of job %s did not start within %s", jobName, timeout))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+type containerStatus struct {
+	Name  string `json:"name"`
+	State struct {
+		Waiting *struct {
+			Reason string `json:"reason"`
+		} `json:"waiting"`
+		Running    *struct{} `json:"running"`
+		Terminated *struct{} `json:"terminated"`
+	} `json:"state"`
+}
+
+// kubectlWaitForContainer waits until the init or ephemeral container is running and fails fast if its image cannot be pulled
+func kubectlWaitForContainer(podName, containerName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var obj struct {
+			Status struct {
+				InitContainerStatuses      []containerStatus `json:"initContainerStatuses"`
+				EphemeralContainerStatuses []containerStatus `json:"ephemeralContainerStatuses"`
+			} `json:"status"`
+		}
+		if err := kubectl(options{
+			Args:      []string{"get", "pod", podName, "-o", "json"},
+			ParseJSON: &obj,
+		}); err != nil {
+			return err
+		}
+		for _, status := range append(obj.Status.InitContainerStatuses, obj.Status.EphemeralContainerStatuses...) {
+			if status.Name != containerName {
+				continue
+			}
+			if status.State.Running != nil {
+				return nil
+			}
+			if status.State.Terminated != nil {
+				return fmt.Errorf("container %s of pod %s terminated unexpectedly", containerName, podName)
+			}
+			if status.State.Waiting != nil {
+				switch status.State.Waiting.Reason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError":
+					return fmt.Errorf("container %s of pod %s cannot start: %s", containerName, podName, status.State.Waiting.Reason)
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return schedulingTimeoutError(fmt.Errorf("container %s of pod %s did not start within %s", containerName, podName, timeout))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// kubectlAttachStdin sends data to a container with stdinOnce, which closes its stdin afterwards
+func kubectlAttachStdin(podName, containerName, data string) error {
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"attach", "-i", "-c", containerName, podName},
+		Silent: true,
+		StdIn:  data,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func kubectlWaitForJobExitCode(jobName string, timeout time.Duration) (int, error) {
+	// the container status is updated shortly after the log stream ends
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := kubectlGetJobPodStatus(jobName)
+		if err != nil {
+			return 0, err
+		}
+		if status != nil && status.ExitCode != nil {
+			return *status.ExitCode, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("job %s did not complete within %s", jobName, timeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func kubectlStreamLogs(podName string) error {
+	return kubectl(options{
+		Args:    []string{"logs", "--follow", "pod/" + podName},
+		PipeAll: true,
+	})
+}
+
+func kubectlDeleteNamespace(name string) error {
+	return kubectl(options{
+		Args: []string{"delete", "--wait=false", "namespace", name},
+	})
+}
+
 func kubectlDeleteNetworkPolicy(name string) error {
 	return kubectl(options{
-		Args: []string{"delete", "--wait=false", "netpol", name},
+		Args: []string{"delete", "--wait=false", "--ignore-not-found", "netpol", name},
+	})
+}
+
+func kubectlGetNetworkPolicies(namespace string) ([]NetworkPolicy, error) {
+	var obj struct {
+		Items []NetworkPolicy `json:"items"`
+	}
+
+	args := []string{"get", "networkpolicies", "-n", namespace, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return nil, err
+	}
+	return obj.Items, nil
+}
+
+func kubectlGetNetworkPolicyManifest(name string) (*NetworkPolicyManifest, error) {
+	out, err := kubectlGetOutput(options{
+		Args:   []string{"get", "networkpolicy", name, "--ignore-not-found", "-o", "json"},
+		Silent: true,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	if len(strings.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+
+	// json is valid yaml, so the manifest types can be reused here
+	var networkPolicyManifest NetworkPolicyManifest
+	if err := yaml.Unmarshal([]byte(out), &networkPolicyManifest); err != nil {
+		return nil, fmt.Errorf("parse network policy: %w", err)
+	}
+	return &networkPolicyManifest, nil
+}
+
+func kubectlGetNetworkEndpoint(namespace, podName string) (NetworkEndpoint, error) {
+	var obj struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Ports []struct {
+					Name          string `json:"name"`
+					ContainerPort int    `json:"containerPort"`
+				} `json:"ports"`
+			} `json:"containers"`
+		} `json:"spec"`
+		Status struct {
+			PodIP string `json:"podIP"`
+		} `json:"status"`
+	}
+
+	args := []string{"get", "pod", podName, "-n", namespace, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return NetworkEndpoint{}, err
+	}
+
+	namespaceLabels, err := kubectlGetNamespaceLabels(namespace)
+	if err != nil {
+		return NetworkEndpoint{}, fmt.Errorf("get labels of namespace %q: %w", namespace, err)
+	}
+
+	endpoint := NetworkEndpoint{
+		Name:            podName,
+		Namespace:       namespace,
+		NamespaceLabels: namespaceLabels,
+		Labels:          obj.Metadata.Labels,
+		IP:              obj.Status.PodIP,
+		NamedPorts:      make(map[string]int),
+	}
+	for _, c := range obj.Spec.Containers {
+		for _, p := range c.Ports {
+			if len(p.Name) > 0 {
+				endpoint.NamedPorts[p.Name] = p.ContainerPort
+			}
+		}
+	}
+	return endpoint, nil
+}
+
+type ServiceTarget struct {
+	Selector   map[string]string
+	TargetPort interface{}
+	PodNames   []string
+}
+
+func kubectlGetServiceTarget(namespace, serviceName string, port int) (ServiceTarget, error) {
+	var obj struct {
+		Spec struct {
+			Selector map[string]string `json:"selector"`
+			Ports    []struct {
+				Port       int         `json:"port"`
+				TargetPort interface{} `json:"targetPort"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}
+
+	args := []string{"get", "service", serviceName, "-n", namespace, "-o", "json"}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &obj,
+	}); err != nil {
+		return ServiceTarget{}, err
+	}
+	if len(obj.Spec.Selector) == 0 {
+		return ServiceTarget{}, fmt.Errorf("service %q has no selector", serviceName)
+	}
+
+	target := ServiceTarget{Selector: obj.Spec.Selector}
+	for _, p := range obj.Spec.Ports {
+		if p.Port == port {
+			target.TargetPort = p.TargetPort
+			if target.TargetPort == nil {
+				target.TargetPort = float64(port)
+			}
+		}
+	}
+	if target.TargetPort == nil {
+		return ServiceTarget{}, fmt.Errorf("service %q has no port %d", serviceName, port)
+	}
+
+	var pods struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	args = []string{"get", "pods", "-n", namespace, "-o", "json"}
+	for k, v := range obj.Spec.Selector {
+		args = append(args, "-l", k+"="+v)
+	}
+	if err := kubectl(options{
+		Args:      args,
+		ParseJSON: &pods,
+	}); err != nil {
+		return ServiceTarget{}, err
+	}
+	for _, item := range pods.Items {
+		if item.Status.Phase == "Running" {
+			target.PodNames = append(target.PodNames, item.Metadata.Name)
+		}
+	}
+	return target, nil
 }
 
 type options struct {
-	Args      []string
-	PipeAll   bool
-	Silent    bool
-	StdIn     string
+	Args    []string
+	PipeAll bool
+	// StderrCapture additionally receives stderr for PipeAll and Stdout
+	StderrCapture io.Writer
+	Silent        bool
+	StdIn         string
+	// Stdout receives stdout instead of the returned output, which then only contains stderr
+	Stdout    io.Writer
 	ParseJSON interface{}
 }
 
@@ -225,7 +1151,16 @@ func kubectlGetOutput(options options) (string, error) {
 		return "", fmt.Errorf("cannot set PipeAll and ParseJSON at the same time")
 	}
 
-	cmd := exec.Command("kubectl", options.Args...)
+	args := options.Args
+	if len(kubectlContext) > 0 && len(tempKubeconfigPath) == 0 {
+		// without temp kubeconfig the context can only be selected per call
+		args = append([]string{"--context", kubectlContext}, args...)
+	}
+	if len(kubectlNamespace) > 0 {
+		// explicit namespace args of single calls come later and take precedence
+		args = append([]string{"--namespace", kubectlNamespace}, args...)
+	}
+	cmd := exec.Command("kubectl", args...)
 	if len(tempKubeconfigPath) > 0 {
 		cmd.Env = os.Environ()
 		cmd.Env = append(cmd.Env, "KUBECONFIG="+tempKubeconfigPath)
@@ -234,20 +1169,44 @@ func kubectlGetOutput(options options) (string, error) {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-		return "", cmd.Run()
+		if options.StderrCapture != nil {
+			cmd.Stderr = io.MultiWriter(os.Stderr, options.StderrCapture)
+		}
+		if err := cmd.Run(); err != nil {
+			return "", clusterError(err)
+		}
+		return "", nil
 	}
 	if len(options.StdIn) > 0 {
 		cmd.Stdin = strings.NewReader(options.StdIn)
 	}
+	if options.Stdout != nil {
+		var stderr bytes.Buffer
+		cmd.Stdout = options.Stdout
+		cmd.Stderr = &stderr
+		if options.StderrCapture != nil {
+			cmd.Stderr = io.MultiWriter(&stderr, options.StderrCapture)
+		}
+		if err := cmd.Run(); err != nil {
+			return stderr.String(), clusterError(err)
+		}
+		return stderr.String(), nil
+	}
 	out, err := cmd.CombinedOutput()
+	if err != nil {
+		err = clusterError(err)
+	}
 	if options.ParseJSON != nil {
+		if err != nil {
+			return string(out), fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
 		if err := json.Unmarshal(out, options.ParseJSON); err != nil {
 			return "", fmt.Errorf("parse json: %w", err)
 		}
 
 	} else {
 		if !options.Silent {
-			fmt.Println(strings.TrimSpace(string(out)))
+			fmt.Fprintln(infoOut, strings.TrimSpace(string(out)))
 		}
 	}
 	return string(out), err
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubectl puts a kubectl running the shell script in front of PATH
+func fakeKubectl(t *testing.T, script string) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kubectl"), []byte("#!/bin/sh\n"+script), 0755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestKubectlWaitForPod(t *testing.T) {
+	fakeKubectl(t, "echo 'error: timed out waiting for the condition on pods/testpod-dev' >&2\nexit 1\n")
+	require.Equal(t, ExitCodeSchedulingTimeout, getExitCode(kubectlWaitForPod("testpod-dev")))
+
+	fakeKubectl(t, "echo 'Error from server (Forbidden): pods \"testpod-dev\" is forbidden' >&2\nexit 1\n")
+	require.Equal(t, ExitCodeClusterError, getExitCode(kubectlWaitForPod("testpod-dev")))
+
+	fakeKubectl(t, "echo 'pod/testpod-dev condition met'\n")
+	require.NoError(t, kubectlWaitForPod("testpod-dev"))
+}
@@ -1,27 +1,67 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	cli struct {
+		Namespace string `name:"namespace" short:"n" help:"namespace to use for all kubectl calls instead of the current namespace of the kubeconfig"`
+		Context   string `name:"context" help:"kubeconfig context to use. only the temporary copy of the kubeconfig is changed"`
+		// AgentBinary is global, as the agent is used by several commands
+		AgentBinary string `name:"agent-binary" help:"static linux build of testpod to inject as agent. defaults to the own binary or testpod-linux-<arch> in the config dir"`
+
 		List struct {
 		} `cmd:"list" help:"List all running testpods."`
 
 		Run struct {
+			Template         string   `name:"template" help:"name of the template file in the config dir without extension"`
+			Last             bool     `name:"last" help:"replay the parameters of the last run. other flags override the stored values"`
+			FromHistory      int      `name:"from-history" help:"replay the parameters of the run with the given history id. other flags override the stored values"`
 			OverrideImage    string   `name:"image" help:"set to override default image from template"`
 			OverrideShell    string   `name:"shell" help:"set to override default shell from template"`
 			Labels           []string `name:"label" short:"l" help:"set additional pod labels in a format like key=value"`
+			CPU              string   `name:"cpu" help:"set cpu request and limit like 100m or 100m:500m"`
+			Memory           string   `name:"memory" help:"set memory request and limit like 128Mi or 128Mi:1Gi"`
+			EphemeralStorage string   `name:"ephemeral-storage" help:"set ephemeral-storage request and limit like 1Gi or 1Gi:2Gi"`
+			Security         string   `name:"security" enum:",restricted,baseline,privileged" default:"" help:"security preset (restricted, baseline or privileged). defaults to the enforced pod security level of the namespace"`
+			Capabilities     []string `name:"cap" help:"add linux capability to the container like NET_ADMIN"`
+			HostNetwork      *bool    `name:"host-network" negatable:"" help:"run the pod in the host network namespace of the node"`
+			HostPID          *bool    `name:"host-pid" negatable:"" help:"run the pod in the host process namespace of the node"`
+			HostIPC          *bool    `name:"host-ipc" negatable:"" help:"run the pod in the host ipc namespace of the node"`
+			ServiceAccount   string   `name:"service-account" help:"set service account of the pod"`
+			AutomountToken   *bool    `name:"automount-service-account-token" negatable:"" help:"mount or do not mount the service account token into the pod"`
 			Node             string   `name:"node" help:"specify node name on which to run the pod"`
 			SelectNode       bool     `name:"select-node" help:"select node interactively"`
+			Like             string   `name:"like" help:"mimic service account, env, volumes, scheduling and labels of a workload like deployment/foo"`
+			LikeInclude      []string `name:"like-include" help:"only copy these aspects for --like (service-account, env, volumes, node-selector, tolerations, image-pull-secrets, labels)"`
+			LikeExclude      []string `name:"like-exclude" help:"do not copy these aspects for --like"`
+			EphemeralNS      *bool    `name:"ephemeral-namespace" negatable:"" help:"run the pod in a new namespace that is deleted afterwards"`
+			Agent            *bool    `name:"agent" negatable:"" help:"inject the testpod agent, which provides a shell, probes and file transfer for images without any tools"`
+			AllNodes         *bool    `name:"all-nodes" negatable:"" help:"run the command on all worker nodes and print a summary"`
+			Nodes            []string `name:"nodes" help:"run the command on the given nodes and print a summary"`
+			Parallel         int      `name:"parallel" default:"5" help:"maximum number of nodes to run the command on at the same time for --all-nodes and --nodes"`
+			Reason           string   `name:"reason" help:"reason for running the testpod. required in protected contexts and namespaces"`
+			Rm               bool     `name:"rm" help:"alias for compatibility with docker run --rm. testpods are always deleted when the session ends"`
 			DryRun           bool     `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
 			NoTempKubeConfig bool     `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+			Command          []string `arg:"" optional:"" name:"command" help:"command to run instead of the shell, specified after --"`
 		} `cmd:"run" default:"withargs" help:"Run a new testpod. Default command if none is specified."`
 
 		Enter struct {
@@ -30,6 +70,201 @@ var (
 			DryRun           bool   `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
 			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
 		} `cmd:"enter" help:"Enter another shell on a running testpod."`
+
+		Exec struct {
+			Pod              string   `arg:"" optional:"" name:"pod" help:"name of the pod. defaults to your running testpod"`
+			Command          []string `arg:"" optional:"" name:"command" help:"command to run instead of the shell, specified after --"`
+			Mine             bool     `name:"mine" help:"ignore all testpods not managed by you"`
+			OverrideShell    string   `name:"shell" help:"set to override default shell from template"`
+			NoTempKubeConfig bool     `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"exec" help:"Run a command in a running testpod. Works without terminal and returns the exit code of the command."`
+
+		Debug struct {
+			Pod              string `arg:"" name:"pod" help:"name of the pod to debug"`
+			Target           string `name:"target" help:"name of the container to share the process namespace with. can be omitted for pods with a single container"`
+			OverrideImage    string `name:"image" help:"set to override default image from template"`
+			OverrideShell    string `name:"shell" help:"set to override default shell from template"`
+			Profile          string `name:"profile" help:"profile of kubectl debug like general, restricted, netadmin or sysadmin. defaults to the security preset"`
+			Reason           string `name:"reason" help:"reason for debugging the pod. required in protected contexts and namespaces"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"debug" help:"Attach an ephemeral debug container to a running pod."`
+
+		Clone struct {
+			Pod              string `arg:"" name:"pod" help:"name of the pod to clone"`
+			Container        string `name:"container" short:"c" help:"name of the container to replace the command of. defaults to the first container"`
+			OverrideShell    string `name:"shell" help:"set to override default shell from template"`
+			Reason           string `name:"reason" help:"reason for cloning the pod. required in protected contexts and namespaces"`
+			DryRun           bool   `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"clone" help:"Run a copy of a pod with the command replaced from template."`
+
+		RbacCheck struct {
+			ServiceAccount   string `name:"service-account" required:"" help:"name of the service account to check"`
+			Inside           bool   `name:"inside" help:"check from inside a testpod running with the service account. the image needs to provide kubectl"`
+			OverrideImage    string `name:"image" help:"set to override default image from template"`
+			Reason           string `name:"reason" help:"reason for running the testpod with --inside. required in protected contexts and namespaces"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"rbac-check" help:"Print the permission matrix of a service account."`
+
+		Netpol struct {
+			Explain struct {
+				To               string `name:"to" required:"" help:"target like svc/name:port or pod/name:port"`
+				ToNamespace      string `name:"to-namespace" help:"namespace of the target. defaults to the current namespace"`
+				From             string `name:"from" help:"name of the source testpod. defaults to your running testpod"`
+				Protocol         string `name:"protocol" enum:"TCP,UDP,SCTP" default:"TCP" help:"protocol of the connection (TCP, UDP or SCTP)"`
+				NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+			} `cmd:"explain" help:"Explain whether NetworkPolicies allow a connection from a testpod to a target."`
+		} `cmd:"netpol" help:"Inspect NetworkPolicies."`
+
+		Netcheck struct {
+			Matrix struct {
+				Nodes            []string      `name:"nodes" help:"only check the given nodes. defaults to all worker nodes"`
+				PerZone          bool          `name:"per-zone" help:"only check one node per zone"`
+				Protocol         string        `name:"protocol" enum:"TCP,UDP" default:"TCP" help:"protocol to check (TCP or UDP)"`
+				Port             int           `name:"port" default:"7777" help:"port the testpods listen on"`
+				Timeout          time.Duration `name:"timeout" default:"2s" help:"timeout of a single probe"`
+				Parallel         int           `name:"parallel" default:"5" help:"maximum number of testpods to start or probe from at the same time"`
+				OverrideImage    string        `name:"image" help:"set to override default image from template. the image does not need to provide any tools"`
+				JSON             bool          `name:"json" help:"print the matrix as json"`
+				Reason           string        `name:"reason" help:"reason for running the check. required in protected contexts and namespaces"`
+				DryRun           bool          `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+				NoTempKubeConfig bool          `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+			} `cmd:"matrix" help:"Check pod-to-pod connectivity between nodes and print a reachability matrix."`
+		} `cmd:"netcheck" help:"Check the cluster network."`
+
+		Probe struct {
+			Kind             string        `arg:"" enum:"dns,tcp,http,tls" help:"kind of probe: dns, tcp, http or tls"`
+			Target           string        `arg:"" help:"host for dns, host:port for tcp and tls, url for http"`
+			Timeout          time.Duration `name:"timeout" default:"5s" help:"timeout of the probe"`
+			Node             string        `name:"node" help:"run the probe from the given node"`
+			OverrideImage    string        `name:"image" help:"set to override default image from template. the image does not need to provide any tools"`
+			JSON             bool          `name:"json" help:"print the result as json"`
+			Reason           string        `name:"reason" help:"reason for running the probe. required in protected contexts and namespaces"`
+			DryRun           bool          `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+			NoTempKubeConfig bool          `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"probe" help:"Run a DNS, TCP, HTTP or TLS probe from inside the cluster."`
+
+		Bench struct {
+			Net struct {
+				From             string        `name:"from" required:"" help:"node to send from"`
+				To               string        `name:"to" required:"" help:"node to send to, or a Service like svc/name:port to only measure the tcp connect RTT"`
+				Protocols        []string      `name:"protocol" default:"TCP,UDP" help:"protocols to measure (TCP, UDP)"`
+				Port             int           `name:"port" default:"7778" help:"port of the receiving testpod"`
+				Duration         time.Duration `name:"duration" default:"5s" help:"duration of each throughput test"`
+				Samples          int           `name:"samples" default:"100" help:"number of round trips to measure RTT and jitter"`
+				UDPRate          int           `name:"udp-rate" default:"100" help:"send rate of the udp throughput test in Mbit/s"`
+				OverrideImage    string        `name:"image" help:"set to override default image from template. the image does not need to provide any tools"`
+				JSON             bool          `name:"json" help:"print the results as json"`
+				Reason           string        `name:"reason" help:"reason for running the benchmark. required in protected contexts and namespaces"`
+				DryRun           bool          `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+				NoTempKubeConfig bool          `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+			} `cmd:"net" help:"Measure throughput, RTT and jitter between two nodes."`
+		} `cmd:"bench" help:"Run benchmarks in the cluster."`
+
+		Cp struct {
+			Source           string `arg:"" name:"source" help:"local file or pod:path"`
+			Destination      string `arg:"" name:"destination" help:"local file or pod:path"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"cp" help:"Copy a file from or to a testpod running the agent."`
+
+		Capture struct {
+			Target           string `arg:"" name:"target" help:"pod to capture the traffic of, or node/name to capture on the host network of a node"`
+			Filter           string `name:"filter" short:"f" help:"bpf filter expression passed to tcpdump"`
+			Write            string `name:"write" short:"w" required:"" help:"pcap file to write, or - for stdout"`
+			Interface        string `name:"interface" short:"i" default:"any" help:"network interface to capture on"`
+			Count            int    `name:"count" short:"c" help:"stop after receiving the given number of packets"`
+			OverrideImage    string `name:"image" help:"set to override capture image from template. the image needs to provide tcpdump"`
+			Reason           string `name:"reason" help:"reason for capturing traffic. required in protected contexts and namespaces"`
+			DryRun           bool   `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"capture" help:"Capture network traffic of a pod or node with tcpdump."`
+
+		Agent struct {
+			Sleep struct{} `cmd:"sleep" help:"wait until the pod is deleted"`
+			Shell struct{} `cmd:"shell" help:"open a minimal shell"`
+			Probe struct {
+				Kind    string        `arg:"" enum:"dns,tcp,http,tls" help:"kind of probe: dns, tcp, http or tls"`
+				Target  string        `arg:"" help:"host for dns, host:port for tcp and tls, url for http"`
+				Timeout time.Duration `name:"timeout" default:"5s" help:"timeout of the probe"`
+			} `cmd:"probe" help:"run a probe and print the result as json"`
+			Listen struct {
+				Protocol string `name:"protocol" enum:"TCP,UDP" default:"TCP" help:"protocol to listen on (TCP or UDP)"`
+				Port     int    `name:"port" required:"" help:"port to listen on"`
+			} `cmd:"listen" help:"echo everything received on the port until the pod is deleted"`
+			Reach struct {
+				Protocol string        `name:"protocol" enum:"TCP,UDP" default:"TCP" help:"protocol to check (TCP or UDP)"`
+				Port     int           `name:"port" required:"" help:"port of the echo listeners"`
+				Timeout  time.Duration `name:"timeout" default:"2s" help:"timeout of a single check"`
+				Targets  []string      `arg:"" name:"targets" help:"addresses of the echo listeners"`
+			} `cmd:"reach" help:"check the echo listeners of other agents and print the results as json"`
+			BenchServer struct {
+				Port int `name:"port" required:"" help:"tcp and udp port to listen on"`
+			} `cmd:"bench-server" help:"serve the bench protocol until the pod is deleted"`
+			Bench struct {
+				Address     string        `arg:"" name:"address" help:"host:port of the bench server"`
+				Protocol    string        `name:"protocol" enum:"TCP,UDP" default:"TCP" help:"protocol to measure (TCP or UDP)"`
+				Duration    time.Duration `name:"duration" default:"5s" help:"duration of the throughput test"`
+				Samples     int           `name:"samples" default:"100" help:"number of round trips to measure"`
+				UDPRate     int           `name:"udp-rate" default:"100" help:"send rate of the udp throughput test in Mbit/s"`
+				ConnectOnly bool          `name:"connect-only" help:"only measure the tcp connect RTT"`
+			} `cmd:"bench" help:"measure against a bench server and print the result as json"`
+			Get struct {
+				Path string `arg:"" name:"path"`
+			} `cmd:"get" help:"write the file to stdout"`
+			Put struct {
+				Path string `arg:"" name:"path"`
+			} `cmd:"put" help:"write stdin to the file"`
+		} `cmd:"agent" hidden:"" help:"Commands of the testpod agent inside pods."`
+
+		NodeShell struct {
+			OverrideImage    string `name:"image" help:"set to override default image from template"`
+			Node             string `name:"node" help:"specify node name instead of selecting it interactively"`
+			Reason           string `name:"reason" help:"reason for opening the node shell. required in protected contexts and namespaces"`
+			DryRun           bool   `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+			NoTempKubeConfig bool   `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+		} `cmd:"node-shell" help:"Open a root shell on a node."`
+
+		Job struct {
+			OverrideImage    string        `name:"image" help:"set to override default image from template"`
+			Labels           []string      `name:"label" short:"l" help:"set additional pod labels in a format like key=value"`
+			Security         string        `name:"security" enum:",restricted,baseline,privileged" default:"" help:"security preset (restricted, baseline or privileged). defaults to the enforced pod security level of the namespace"`
+			Node             string        `name:"node" help:"specify node name on which to run the job"`
+			StartTimeout     time.Duration `name:"start-timeout" default:"30s" help:"maximum time to wait for the pod of the job to start"`
+			TTL              time.Duration `name:"ttl" default:"10m" help:"time after which kubernetes deletes the finished job in case testpod cannot clean it up"`
+			Reason           string        `name:"reason" help:"reason for running the job. required in protected contexts and namespaces"`
+			DryRun           bool          `name:"dry-run" help:"print manifest instead of applying it to kubernetes"`
+			NoTempKubeConfig bool          `name:"no-temp-kubeconfig" help:"do not use temporary copy of kubeconfig file"`
+			Command          []string      `arg:"" name:"command" help:"command to run, specified after --"`
+		} `cmd:"job" help:"Run a command once as Kubernetes Job, stream its logs and return its exit code."`
+
+		History struct {
+			Since string `name:"since" help:"only show records since date like 2006-01-02 or timestamp"`
+			Until string `name:"until" help:"only show records until date like 2006-01-02 (inclusive) or timestamp"`
+			Image string `name:"image" help:"only show records with images containing the given string"`
+			JSON  bool   `name:"json" help:"print matching records as json lines"`
+		} `cmd:"history" help:"Show the local audit log. Use --context and --namespace to filter."`
+	}
+
+	// infoOut receives status messages. it is switched to stderr when stdout belongs to a remote command
+	infoOut io.Writer = os.Stdout
+
+	auditedCommands = map[string]string{
+		"run":                       "run",
+		"run <command>":             "run",
+		"enter":                     "enter",
+		"exec":                      "exec",
+		"exec <pod>":                "exec",
+		"exec <pod> <command>":      "exec",
+		"job <command>":             "job",
+		"debug <pod>":               "debug",
+		"clone <pod>":               "clone",
+		"rbac-check":                "rbac-check",
+		"node-shell":                "node-shell",
+		"netcheck matrix":           "netcheck",
+		"probe <kind> <target>":     "probe",
+		"cp <source> <destination>": "cp",
+		"bench net":                 "bench",
+		"capture <target>":          "capture",
 	}
 )
 
@@ -37,11 +272,68 @@ func main() {
 	//TODO cleanup on ctrl+c
 	// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-sigint-and-run-a-cleanup-function-i
 
-	ctx := kong.Parse(&cli)
-	if err := execCmd(ctx.Command()); err != nil {
-		fmt.Println("ERR:", err)
-		os.Exit(1)
+	ctx := kong.Parse(&cli, kong.Exit(func(exitCode int) {
+		if exitCode != 0 {
+			exitCode = ExitCodeUsageError
+		}
+		os.Exit(exitCode)
+	}))
+	if strings.HasPrefix(ctx.Command(), "agent") {
+		// the agent runs inside pods without kubectl
+		exitWithError(execCmdAgent(ctx.Command()))
+		return
+	}
+	if ctx.Command() == "history" {
+		// context and namespace are only used as filters and do not need to exist
+		exitWithError(execCmdHistory())
+		return
+	}
+
+	if strings.HasPrefix(ctx.Command(), "exec") {
+		cli.Exec.Pod, cli.Exec.Command = fixPodAndCommandArgs(cli.Exec.Pod, cli.Exec.Command, os.Args[1:])
+	}
+	// commands printing results to stdout keep their status messages out of the way
+	if len(cli.Run.Command) > 0 || len(cli.Exec.Command) > 0 || len(cli.Job.Command) > 0 || ctx.Command() == "netcheck matrix" || ctx.Command() == "bench net" || ctx.Command() == "capture <target>" || cli.Probe.JSON {
+		infoOut = os.Stderr
+	}
+
+	kubectlNamespace = cli.Namespace
+	if len(cli.Context) > 0 {
+		exitWithError(validateKubectlContext(cli.Context))
+		kubectlContext = cli.Context
+	}
+	if action, ok := auditedCommands[ctx.Command()]; ok {
+		startAuditSession(action)
+	}
+	err := execCmd(ctx.Command())
+	finishAuditSession(err)
+	exitWithError(err)
+}
+
+func exitWithError(err error) {
+	if err == nil {
+		return
+	}
+	exitCode := getExitCode(err)
+	var remoteExitErr *RemoteExitError
+	// kubectl already reported failed remote commands and cleanup warnings have been printed
+	if !errors.As(err, &remoteExitErr) && exitCode != ExitCodeCleanupWarning {
+		fmt.Fprintln(infoOut, "ERR:", err)
+	}
+	os.Exit(exitCode)
+}
+
+// fixPodAndCommandArgs moves the optional pod back to the command if it was only given after --
+func fixPodAndCommandArgs(pod string, command []string, args []string) (string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			if len(args)-i-1 > len(command) {
+				return "", append([]string{pod}, command...)
+			}
+			break
+		}
 	}
+	return pod, command
 }
 
 func execCmd(cmd string) error {
@@ -49,14 +341,50 @@ func execCmd(cmd string) error {
 	case "list":
 		return execCmdList()
 
-	case "run":
+	case "run", "run <command>":
 		return execCmdRun()
 
 	case "enter":
 		return execCmdEnter()
 
+	case "exec", "exec <pod>", "exec <pod> <command>":
+		return execCmdExec()
+
+	case "job <command>":
+		return execCmdJob()
+
+	case "debug <pod>":
+		return execCmdDebug()
+
+	case "clone <pod>":
+		return execCmdClone()
+
+	case "rbac-check":
+		return execCmdRbacCheck()
+
+	case "netpol explain":
+		return execCmdNetpolExplain()
+
+	case "node-shell":
+		return execCmdNodeShell()
+
+	case "netcheck matrix":
+		return execCmdNetcheckMatrix()
+
+	case "probe <kind> <target>":
+		return execCmdProbe()
+
+	case "cp <source> <destination>":
+		return execCmdCp()
+
+	case "bench net":
+		return execCmdBenchNet()
+
+	case "capture <target>":
+		return execCmdCapture()
+
 	default:
-		return fmt.Errorf("unknown command %q", cmd)
+		return usageErrorf("unknown command %q", cmd)
 	}
 }
 
@@ -64,31 +392,52 @@ func execCmdList() error {
 	if err := kubectlListPods(map[string]string{"app.kubernetes.io/name": "go-testpod"}); err != nil {
 		return fmt.Errorf("list testpods: %w", err)
 	}
+
+	debugContainers, err := kubectlGetDebugContainers()
+	if err != nil {
+		return fmt.Errorf("list testpod debug containers: %w", err)
+	}
+	if len(debugContainers) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "NAME\tDEBUG CONTAINER\tTARGET\tSTATE")
+		for _, c := range debugContainers {
+			fmt.Fprintf(w, "%s [debug]\t%s\t%s\t%s\n", c.PodName, c.ContainerName, c.TargetName, c.State)
+		}
+		w.Flush()
+	}
 	return nil
 }
 
 func execCmdRun() error {
-	return withKubeConfig(cli.Run.NoTempKubeConfig, func() error {
-		additionalPodLabels := make(map[string]string)
-		for _, str := range cli.Run.Labels {
-			parts := strings.SplitN(str, "=", 2)
-			if len(parts) != 2 {
-				return fmt.Errorf("label must be like \"foo=bar\", got %q instead", str)
-			}
-			if _, ok := additionalPodLabels[parts[0]]; ok {
-				return fmt.Errorf("label %q is defined multiple times", parts[0])
-			}
-			additionalPodLabels[parts[0]] = parts[1]
+	params, err := getRunParameters()
+	if err != nil {
+		return err
+	}
+	if params.Context != kubectlContext {
+		// stored context of a replayed run
+		if err := validateKubectlContext(params.Context); err != nil {
+			return err
 		}
+		kubectlContext = params.Context
+	}
+	kubectlNamespace = params.Namespace
 
-		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
-			Image:               cli.Run.OverrideImage,
-			Shell:               cli.Run.OverrideShell,
-			AdditionalPodLabels: additionalPodLabels,
-		})
+	return withKubeConfig(cli.Run.NoTempKubeConfig, func() (err error) {
+		tpl, err := ReadTemplateWithOverrides(params.Overrides)
 		if err != nil {
 			return fmt.Errorf("read template: %w", err)
 		}
+		// only explicitly requested privileged presets are guarded, detected ones are allowed by the namespace anyway
+		privilegedPreset := tpl.Pod.Security == SecurityPresetPrivileged
+		if len(tpl.Pod.Security) == 0 {
+			if isTrue(params.EphemeralNamespace) {
+				// a new namespace has no pod security level enforced
+				tpl.Pod.Security = SecurityPresetPrivileged
+			} else {
+				tpl.Pod.Security = detectSecurityPreset()
+			}
+		}
 
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -97,119 +446,1899 @@ func execCmdRun() error {
 		managedBy := hostname
 		podName := makePodName(hostname, time.Now())
 
-		var nodeName string
-		if len(cli.Run.Node) > 0 {
-			if cli.Run.SelectNode {
-				return fmt.Errorf("cannot specify --node and --select-node at the same time")
-			}
-			nodeName = cli.Run.Node
-		} else if cli.Run.SelectNode {
-			nodes, err := kubectlGetWorkerNodes()
-			if err != nil {
-				return fmt.Errorf("get node names: %w", err)
+		usedOptions := GetUsedOptions(tpl.Pod)
+		if privilegedPreset {
+			usedOptions = append(usedOptions, OptionPrivileged)
+		}
+
+		if isTrue(params.AllNodes) || len(params.Nodes) > 0 {
+			return runTestpodOnNodes(params, tpl, usedOptions, managedBy, podName)
+		}
+
+		var namespaceManifestData string
+		if isTrue(params.EphemeralNamespace) {
+			if len(params.Like) > 0 {
+				return usageErrorf("cannot specify --like and --ephemeral-namespace at the same time")
 			}
-			selectedNodeIndex, err := InteractiveSelect(nodes, func(item Node) string {
-				return fmt.Sprintf("%s  (%s)  %s", item.Name, item.Version, FormatDuration(item.Age))
-			})
+			namespaceManifestData, err = MakeNamespaceManifest(managedBy, podName)
 			if err != nil {
-				return fmt.Errorf("interactive node selection failed: %w", err)
+				return fmt.Errorf("render manifest: %w", err)
 			}
-			nodeName = nodes[selectedNodeIndex].Name
 		}
-		var nodeLabels map[string]string
-		if len(nodeName) > 0 {
-			labels, err := kubectlGetNodeLabels(nodeName, map[string]bool{
-				"beta.kubernetes.io/arch":          true,
-				"beta.kubernetes.io/os":            true,
-				"beta.kubernetes.io/instance-type": true,
-			})
-			if err != nil {
-				return fmt.Errorf("get node labels for node %q: %w", nodeName, err)
+
+		nodeName := params.Node
+		if cli.Run.SelectNode && len(cli.Run.Node) == 0 {
+			// interactive selection overrides the stored node of a replayed run
+			nodeName = ""
+		}
+		nodeName, err = selectNodeName(nodeName, cli.Run.SelectNode)
+		if err != nil {
+			return err
+		}
+		auditRunParameters(params, nodeName)
+		nodeLabels, err := getNodeAffinityLabels(nodeName)
+		if err != nil {
+			return err
+		}
+		if isTrue(params.Agent) {
+			if nodeLabels, err = prepareAgent(&tpl, nodeName, nodeLabels); err != nil {
+				return err
 			}
-			nodeLabels = labels
+		}
 
-			//TODO check set of labels is unique
+		podManifest, err := MakePodManifestFromTemplate(managedBy, podName, nodeLabels, tpl)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+		if len(params.Like) > 0 {
+			if err := mergeLikeWorkload(&podManifest, params.Like, params.LikeInclude, params.LikeExclude); err != nil {
+				return err
+			}
+		} else if len(cli.Run.LikeInclude) > 0 || len(cli.Run.LikeExclude) > 0 {
+			return usageErrorf("--like-include and --like-exclude require --like")
 		}
 
-		manifestData, err := MakeManifestFromTemplate(managedBy, podName, nodeLabels, tpl)
+		if len(nodeName) > 0 {
+			usedOptions = append(usedOptions, OptionNode)
+		}
+		if len(params.Like) > 0 {
+			usedOptions = append(usedOptions, OptionLike)
+		}
+		if isTrue(params.EphemeralNamespace) {
+			usedOptions = append(usedOptions, OptionEphemeralNamespace)
+		}
+		guardrail, err := checkGuardrails(usedOptions, cli.Run.Reason)
+		if err != nil {
+			return err
+		}
+		if len(cli.Run.Reason) > 0 {
+			podManifest.Metadata.SetAnnotation(ReasonAnnotation, cli.Run.Reason)
+		}
+		networkPolicyManifest, err := MakeNetworkPolicyManifestFromTemplate(managedBy, podName, tpl)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+		manifestData, err := MarshalManifests(podManifest, networkPolicyManifest)
 		if err != nil {
 			return fmt.Errorf("render manifest: %w", err)
 		}
 
 		if cli.Run.DryRun {
-			fmt.Println("dry-run: print manifest instead of applying it")
-			fmt.Println("###############################")
-			fmt.Println(strings.TrimSpace(manifestData))
-			fmt.Println("###############################")
+			if len(namespaceManifestData) > 0 {
+				manifestData = namespaceManifestData + "\n---\n" + manifestData
+			}
+			printDryRunManifest(manifestData)
 			return nil
 		}
 
-		if err := kubectlApply(manifestData); err != nil {
-			return fmt.Errorf("apply manifest: %w", err)
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
 		}
-		defer func() {
-			if err := kubectlDeletePod(podName); err != nil {
-				fmt.Println("WARN: failed to delete Pod")
+		if tpl.Pod.UsesHostNamespaces() {
+			if err := confirmHostNamespaces(tpl.Pod, nodeName); err != nil {
+				return err
+			}
+		}
+
+		if len(namespaceManifestData) > 0 {
+			if err := kubectlApply(namespaceManifestData); err != nil {
+				return fmt.Errorf("create namespace: %w", err)
 			}
-		}()
-		if tpl.NetworkPolicy.CreateAllowAll {
 			defer func() {
-				if err := kubectlDeleteNetworkPolicy(podName); err != nil {
-					fmt.Println("WARN: failed to delete NetworkPolicy")
+				if deleteErr := kubectlDeleteNamespace(podName); deleteErr != nil {
+					fmt.Fprintln(infoOut, "WARN: failed to delete Namespace")
+					if err == nil {
+						err = cleanupWarning(fmt.Errorf("delete Namespace: %w", deleteErr))
+					}
 				}
 			}()
+			kubectlNamespace = podName
+			if err := kubectlWaitForServiceAccount("default"); err != nil {
+				return fmt.Errorf("wait for default ServiceAccount: %w", err)
+			}
 		}
 
-		if err := kubectlWaitForPod(podName); err != nil {
-			return fmt.Errorf("wait for Pod: %w", err)
+		command := cli.Run.Command
+		if len(command) == 0 {
+			command = []string{tpl.DefaultShell}
+			if isTrue(params.Agent) && len(params.Overrides.Shell) == 0 {
+				command = []string{AgentPath, "agent", "shell"}
+			}
 		}
+		return runTestpod(podName, manifestData, tpl, func() error {
+			if err := kubectlExec(podName, command...); err != nil {
+				return fmt.Errorf("exec into Pod: %w", err)
+			}
+			return nil
+		})
+	})
+}
 
-		if err := kubectlExec(podName, tpl.DefaultShell); err != nil {
-			return fmt.Errorf("exec into Pod: %w", err)
+func parseLabelFlags(labels []string) (map[string]string, error) {
+	additionalPodLabels := make(map[string]string)
+	for _, str := range labels {
+		parts := strings.SplitN(str, "=", 2)
+		if len(parts) != 2 {
+			return nil, usageErrorf("label must be like \"foo=bar\", got %q instead", str)
 		}
-
-		return nil
-	})
+		if _, ok := additionalPodLabels[parts[0]]; ok {
+			return nil, usageErrorf("label %q is defined multiple times", parts[0])
+		}
+		additionalPodLabels[parts[0]] = parts[1]
+	}
+	return additionalPodLabels, nil
 }
 
-func execCmdEnter() error {
-	tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
-		Shell: cli.Enter.OverrideShell,
-	})
+func getRunParameters() (RunParameters, error) {
+	additionalPodLabels, err := parseLabelFlags(cli.Run.Labels)
 	if err != nil {
-		return fmt.Errorf("read template: %w", err)
+		return RunParameters{}, err
 	}
 
-	matchLabels := map[string]string{
-		"app.kubernetes.io/name": "go-testpod",
-	}
-	if cli.Enter.Mine {
-		hostname, err := os.Hostname()
-		if err != nil {
-			return fmt.Errorf("get hostname: %w", err)
+	var resources ResourcesTemplate
+	for resourceName, value := range map[string]string{
+		"cpu":               cli.Run.CPU,
+		"memory":            cli.Run.Memory,
+		"ephemeral-storage": cli.Run.EphemeralStorage,
+	} {
+		if len(value) > 0 {
+			if err := resources.SetResourceFromFlag(resourceName, value); err != nil {
+				return RunParameters{}, err
+			}
 		}
-		matchLabels["app.kubernetes.io/managed-by"] = hostname
 	}
 
-	pods, err := kubectlGetPodNames(matchLabels)
-	if err != nil {
-		return fmt.Errorf("list running pods: %w", err)
+	params := RunParameters{
+		Overrides: TemplateOverrides{
+			Template:                     cli.Run.Template,
+			Image:                        cli.Run.OverrideImage,
+			Shell:                        cli.Run.OverrideShell,
+			AdditionalPodLabels:          additionalPodLabels,
+			Resources:                    resources,
+			Security:                     cli.Run.Security,
+			Capabilities:                 cli.Run.Capabilities,
+			HostNetwork:                  cli.Run.HostNetwork,
+			HostPID:                      cli.Run.HostPID,
+			HostIPC:                      cli.Run.HostIPC,
+			ServiceAccountName:           cli.Run.ServiceAccount,
+			AutomountServiceAccountToken: cli.Run.AutomountToken,
+		},
+		Node:               cli.Run.Node,
+		Like:               cli.Run.Like,
+		LikeInclude:        cli.Run.LikeInclude,
+		LikeExclude:        cli.Run.LikeExclude,
+		EphemeralNamespace: cli.Run.EphemeralNS,
+		Agent:              cli.Run.Agent,
+		AllNodes:           cli.Run.AllNodes,
+		Nodes:              cli.Run.Nodes,
+		Context:            cli.Context,
+		Namespace:          cli.Namespace,
 	}
-	if len(pods) == 0 {
-		return fmt.Errorf("no suitable testpods running in selected context")
+	if !cli.Run.Last && cli.Run.FromHistory == 0 {
+		return params, nil
 	}
-	if len(pods) > 1 {
-		return fmt.Errorf("multiple suitable testpods running in selected context")
+	if cli.Run.Last && cli.Run.FromHistory != 0 {
+		return RunParameters{}, usageErrorf("cannot specify --last and --from-history at the same time")
 	}
-
-	if cli.Enter.DryRun {
-		fmt.Println("dry-run: skip entering pod", pods[0])
-		return nil
+	if cli.Run.FromHistory < 0 {
+		return RunParameters{}, usageErrorf("invalid history id %d", cli.Run.FromHistory)
 	}
 
-	fmt.Println("enter running pod", pods[0])
-	if err := kubectlExec(pods[0], tpl.DefaultShell); err != nil {
-		return fmt.Errorf("exec into Pod: %w", err)
+	records, err := readAuditLog()
+	if err != nil {
+		return RunParameters{}, fmt.Errorf("read audit log: %w", err)
 	}
-	return nil
+	stored, err := FindRunParameters(records, cli.Run.FromHistory)
+	if err != nil {
+		return RunParameters{}, err
+	}
+	return stored.Merge(params), nil
+}
+
+func execCmdDebug() error {
+	return withKubeConfig(cli.Debug.NoTempKubeConfig, func() error {
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image: cli.Debug.OverrideImage,
+			Shell: cli.Debug.OverrideShell,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+
+		targetName := cli.Debug.Target
+		if len(targetName) == 0 {
+			containerNames, err := kubectlGetContainerNames(cli.Debug.Pod)
+			if err != nil {
+				return fmt.Errorf("get containers of pod %q: %w", cli.Debug.Pod, err)
+			}
+			if len(containerNames) != 1 {
+				return usageErrorf("pod %q has multiple containers, select one with --target: %s", cli.Debug.Pod, strings.Join(containerNames, ", "))
+			}
+			targetName = containerNames[0]
+		}
+
+		profile := cli.Debug.Profile
+		if len(profile) == 0 {
+			if len(tpl.Pod.Security) == 0 {
+				tpl.Pod.Security = detectSecurityPreset()
+			}
+			if tpl.Pod.Security != SecurityPresetPrivileged {
+				profile = tpl.Pod.Security
+			}
+		}
+
+		guardrail, err := checkGuardrails(GetUsedOptionsOfDebugProfile(profile), cli.Debug.Reason)
+		if err != nil {
+			return err
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+		if len(cli.Debug.Reason) > 0 {
+			// ephemeral containers have no metadata, so the debugged pod carries the reason
+			if err := kubectlAnnotatePod(cli.Debug.Pod, ReasonAnnotation, cli.Debug.Reason); err != nil {
+				return fmt.Errorf("annotate pod %q: %w", cli.Debug.Pod, err)
+			}
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		containerName := makePodName(hostname, time.Now())
+
+		auditPod(cli.Debug.Pod)
+		if auditSession != nil {
+			auditSession.Image = tpl.DefaultImage
+		}
+		fmt.Fprintln(infoOut, "attach debug container", containerName, "to container", targetName, "of pod", cli.Debug.Pod)
+		if err := kubectlDebug(cli.Debug.Pod, containerName, targetName, tpl.DefaultImage, profile, tpl.DefaultShell); err != nil {
+			return fmt.Errorf("debug Pod: %w", err)
+		}
+		return nil
+	})
+}
+
+func execCmdClone() error {
+	return withKubeConfig(cli.Clone.NoTempKubeConfig, func() error {
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Shell: cli.Clone.OverrideShell,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		podName := makePodName(hostname, time.Now())
+
+		pod, err := kubectlGetPod(cli.Clone.Pod)
+		if err != nil {
+			return fmt.Errorf("get pod %q: %w", cli.Clone.Pod, err)
+		}
+		cloneManifest, err := MakeCloneManifest(managedBy, podName, pod, cli.Clone.Container, tpl)
+		if err != nil {
+			return fmt.Errorf("clone pod %q: %w", cli.Clone.Pod, err)
+		}
+		cloneSpec, _ := cloneManifest["spec"].(map[string]interface{})
+		guardrail, err := checkGuardrails(GetUsedOptionsOfPodSpec(cloneSpec), cli.Clone.Reason)
+		if err != nil {
+			return err
+		}
+		if len(cli.Clone.Reason) > 0 {
+			metadata := cloneManifest["metadata"].(map[string]interface{})
+			metadata["annotations"].(map[string]string)[ReasonAnnotation] = cli.Clone.Reason
+		}
+		networkPolicyManifest, err := MakeNetworkPolicyManifestFromTemplate(managedBy, podName, tpl)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+		manifestData, err := MarshalManifests(cloneManifest, networkPolicyManifest)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+
+		if cli.Clone.DryRun {
+			printDryRunManifest(manifestData)
+			return nil
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		return runTestpod(podName, manifestData, tpl, func() error {
+			fmt.Fprintln(infoOut, "enter clone", podName, "of pod", cli.Clone.Pod)
+			if err := kubectlExec(podName, tpl.DefaultShell); err != nil {
+				return fmt.Errorf("exec into Pod: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+func execCmdRbacCheck() error {
+	return withKubeConfig(cli.RbacCheck.NoTempKubeConfig, func() error {
+		var out string
+		if cli.RbacCheck.Inside {
+			automountToken := true
+			tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+				Image:                        cli.RbacCheck.OverrideImage,
+				ServiceAccountName:           cli.RbacCheck.ServiceAccount,
+				AutomountServiceAccountToken: &automountToken,
+			})
+			if err != nil {
+				return fmt.Errorf("read template: %w", err)
+			}
+			if len(tpl.Pod.Security) == 0 {
+				tpl.Pod.Security = detectSecurityPreset()
+			}
+
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("get hostname: %w", err)
+			}
+			managedBy := hostname
+			podName := makePodName(hostname, time.Now())
+
+			guardrail, err := checkGuardrails(GetUsedOptions(tpl.Pod), cli.RbacCheck.Reason)
+			if err != nil {
+				return err
+			}
+			manifestData, err := renderTestpodManifest(managedBy, podName, nil, tpl, cli.RbacCheck.Reason)
+			if err != nil {
+				return err
+			}
+			if guardrail != nil {
+				if err := confirmGuardrail(guardrail); err != nil {
+					return err
+				}
+			}
+			if err := runTestpod(podName, manifestData, tpl, func() error {
+				out, err = kubectlExecGetStdout(podName, "kubectl", "auth", "can-i", "--list")
+				if err != nil {
+					if strings.Contains(err.Error(), "executable file not found") {
+						return usageErrorf("image %s does not provide kubectl, select an image with kubectl using --image", tpl.DefaultImage)
+					}
+					return fmt.Errorf("run kubectl in Pod: %w", err)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+		} else {
+			namespace, err := kubectlGetCurrentNamespace()
+			if err != nil {
+				return fmt.Errorf("get current namespace: %w", err)
+			}
+			out, err = kubectlAuthCanIList("system:serviceaccount:" + namespace + ":" + cli.RbacCheck.ServiceAccount)
+			if err != nil {
+				return fmt.Errorf("check permissions: %w", err)
+			}
+		}
+
+		permissions, err := ParseCanIList(out)
+		if err != nil {
+			return fmt.Errorf("parse permissions: %w", err)
+		}
+		return WritePermissionMatrix(os.Stdout, permissions)
+	})
+}
+
+func execCmdNetpolExplain() error {
+	return withKubeConfig(cli.Netpol.Explain.NoTempKubeConfig, func() error {
+		kind, name, port, err := ParseNetworkPolicyTarget(cli.Netpol.Explain.To)
+		if err != nil {
+			return err
+		}
+
+		namespace, err := kubectlGetCurrentNamespace()
+		if err != nil {
+			return fmt.Errorf("get current namespace: %w", err)
+		}
+		dstNamespace := namespace
+		if len(cli.Netpol.Explain.ToNamespace) > 0 {
+			dstNamespace = cli.Netpol.Explain.ToNamespace
+		}
+
+		srcPodName := cli.Netpol.Explain.From
+		if len(srcPodName) == 0 {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("get hostname: %w", err)
+			}
+			pods, err := kubectlGetPodNames(map[string]string{
+				"app.kubernetes.io/name":       "go-testpod",
+				"app.kubernetes.io/managed-by": hostname,
+			})
+			if err != nil {
+				return fmt.Errorf("list running pods: %w", err)
+			}
+			if len(pods) != 1 {
+				return usageErrorf("found %d testpods managed by you, select the source pod with --from", len(pods))
+			}
+			srcPodName = pods[0]
+		}
+		src, err := kubectlGetNetworkEndpoint(namespace, srcPodName)
+		if err != nil {
+			return fmt.Errorf("get source pod %q: %w", srcPodName, err)
+		}
+		// temporary egress rules select the testpod by its labels and are deleted together with it
+		if src.Labels["app.kubernetes.io/name"] != "go-testpod" {
+			return usageErrorf("pod %q is not a testpod, select a testpod with --from", srcPodName)
+		}
+
+		dstPodName := name
+		var target ServiceTarget
+		via := ""
+		if kind == "svc" {
+			target, err = kubectlGetServiceTarget(dstNamespace, name, port)
+			if err != nil {
+				return fmt.Errorf("get service %q: %w", name, err)
+			}
+			if len(target.PodNames) == 0 {
+				return fmt.Errorf("service %q has no running pods", name)
+			}
+			dstPodName = target.PodNames[0]
+			via = fmt.Sprintf(" via svc/%s:%d (%d running pods)", name, port, len(target.PodNames))
+		}
+		dst, err := kubectlGetNetworkEndpoint(dstNamespace, dstPodName)
+		if err != nil {
+			return fmt.Errorf("get destination pod %q: %w", dstPodName, err)
+		}
+		dstPort := port
+		dstSelector := dst.Labels
+		if kind == "svc" {
+			dstSelector = target.Selector
+			switch targetPort := target.TargetPort.(type) {
+			case float64:
+				dstPort = int(targetPort)
+			case string:
+				namedPort, ok := dst.NamedPorts[targetPort]
+				if !ok {
+					return fmt.Errorf("pod %q has no port named %q", dstPodName, targetPort)
+				}
+				dstPort = namedPort
+			}
+		}
+
+		policies, err := kubectlGetNetworkPolicies(namespace)
+		if err != nil {
+			return fmt.Errorf("get NetworkPolicies of namespace %q: %w", namespace, err)
+		}
+		if dstNamespace != namespace {
+			dstPolicies, err := kubectlGetNetworkPolicies(dstNamespace)
+			if err != nil {
+				return fmt.Errorf("get NetworkPolicies of namespace %q: %w", dstNamespace, err)
+			}
+			policies = append(policies, dstPolicies...)
+		}
+
+		protocol := cli.Netpol.Explain.Protocol
+		egress := EvaluateEgress(policies, src, dst, protocol, dstPort)
+		ingress := EvaluateIngress(policies, src, dst, protocol, dstPort)
+		fmt.Printf("source:      %s/%s (%s)\n", src.Namespace, src.Name, src.IP)
+		fmt.Printf("destination: %s/%s (%s) port %s/%d%s\n", dst.Namespace, dst.Name, dst.IP, protocol, dstPort, via)
+		fmt.Println("egress:     ", FormatNetworkPolicyVerdict(egress))
+		fmt.Println("ingress:    ", FormatNetworkPolicyVerdict(ingress))
+		if egress.Allowed && ingress.Allowed {
+			fmt.Println("result:      connection is allowed")
+			return nil
+		}
+		fmt.Println("result:      connection is blocked")
+
+		if !ingress.Allowed {
+			fmt.Println("ingress needs to be allowed by a NetworkPolicy in namespace", dst.Namespace)
+		}
+		if !egress.Allowed {
+			ok, err := InteractiveConfirm(fmt.Sprintf("Add temporary egress rule to NetworkPolicy %s", srcPodName))
+			if err != nil {
+				return fmt.Errorf("confirm egress rule: %w", err)
+			}
+			if ok {
+				if err := addTemporaryEgressRule(srcPodName, MakeEgressRuleTo(dst, dstSelector, protocol, dstPort)); err != nil {
+					return fmt.Errorf("add egress rule: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func addTemporaryEgressRule(podName string, rule EgressBlock) error {
+	networkPolicyManifest, err := kubectlGetNetworkPolicyManifest(podName)
+	if err != nil {
+		return fmt.Errorf("get NetworkPolicy: %w", err)
+	}
+	if networkPolicyManifest == nil {
+		// testpods without NetworkPolicy from template get a new one that is deleted together with the pod
+		networkPolicyManifest = &NetworkPolicyManifest{}
+		networkPolicyManifest.APIVersion = "networking.k8s.io/v1"
+		networkPolicyManifest.Kind = "NetworkPolicy"
+		networkPolicyManifest.Metadata.Name = podName
+		networkPolicyManifest.Spec.PodSelector.MatchLabels = map[string]string{
+			"app.kubernetes.io/name":     "go-testpod",
+			"app.kubernetes.io/instance": podName,
+		}
+	}
+	networkPolicyManifest.Spec.Egress = append(networkPolicyManifest.Spec.Egress, rule)
+	hasEgressType := false
+	for _, t := range networkPolicyManifest.Spec.PolicyTypes {
+		if t == "Egress" {
+			hasEgressType = true
+		}
+	}
+	if !hasEgressType {
+		networkPolicyManifest.Spec.PolicyTypes = append(networkPolicyManifest.Spec.PolicyTypes, "Egress")
+	}
+
+	manifestData, err := yaml.Marshal(networkPolicyManifest)
+	if err != nil {
+		return fmt.Errorf("marshal network policy yaml: %w", err)
+	}
+	return kubectlApply(string(manifestData))
+}
+
+func execCmdNodeShell() error {
+	return withKubeConfig(cli.NodeShell.NoTempKubeConfig, func() error {
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image: cli.NodeShell.OverrideImage,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		podName := makePodName(hostname, time.Now())
+
+		nodeName, err := selectNodeName(cli.NodeShell.Node, len(cli.NodeShell.Node) == 0)
+		if err != nil {
+			return err
+		}
+		nodeLabels, err := getNodeAffinityLabels(nodeName)
+		if err != nil {
+			return err
+		}
+		taints, err := kubectlGetNodeTaints(nodeName)
+		if err != nil {
+			return fmt.Errorf("get taints of node %q: %w", nodeName, err)
+		}
+
+		tpl.Pod.Security = SecurityPresetPrivileged
+		tpl.Pod.Privileged = true
+		tpl.Pod.HostPID = true
+		tpl.Pod.HostNetwork = true
+		tpl.Pod.Tolerations = append(tpl.Pod.Tolerations, MakeTolerationsForTaints(taints)...)
+
+		guardrail, err := checkGuardrails(append(GetUsedOptions(tpl.Pod), OptionNodeShell, OptionNode), cli.NodeShell.Reason)
+		if err != nil {
+			return err
+		}
+
+		manifestData, err := renderTestpodManifest(managedBy, podName, nodeLabels, tpl, cli.NodeShell.Reason)
+		if err != nil {
+			return err
+		}
+
+		if cli.NodeShell.DryRun {
+			printDryRunManifest(manifestData)
+			return nil
+		}
+
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		return runTestpod(podName, manifestData, tpl, func() error {
+			fmt.Fprintln(infoOut, "enter host namespaces of node", nodeName)
+			if err := kubectlExec(podName, "nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p"); err != nil {
+				return fmt.Errorf("exec into Pod: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+func mergeLikeWorkload(podManifest *PodManifest, ref string, include, exclude []string) error {
+	aspects, err := SelectLikeAspects(include, exclude)
+	if err != nil {
+		return err
+	}
+
+	workload, err := kubectlGetWorkloadPodTemplate(ref)
+	if err != nil {
+		return fmt.Errorf("get pod template of %q: %w", ref, err)
+	}
+	skippedLabels := MergeWorkloadIntoPodManifest(podManifest, workload, aspects)
+	for _, k := range skippedLabels {
+		fmt.Fprintf(infoOut, "WARN: label %q of %s conflicts with testpod labels and is not copied\n", k, ref)
+	}
+
+	if aspects[LikeAspectLabels] {
+		serviceSelectors, err := kubectlGetServiceSelectors()
+		if err != nil {
+			return fmt.Errorf("get services: %w", err)
+		}
+		serviceNames := make([]string, 0)
+		for name, selector := range serviceSelectors {
+			if MatchesSelector(selector, podManifest.Metadata.Labels) {
+				serviceNames = append(serviceNames, name)
+			}
+		}
+		sort.Strings(serviceNames)
+		for _, name := range serviceNames {
+			fmt.Fprintf(infoOut, "WARN: testpod will join the endpoints of Service %q and receive its traffic. use --like-exclude labels to prevent this\n", name)
+		}
+	}
+	return nil
+}
+
+func selectNodeName(node string, selectNode bool) (string, error) {
+	if len(node) > 0 {
+		if selectNode {
+			return "", usageErrorf("cannot specify --node and --select-node at the same time")
+		}
+		return node, nil
+	}
+	if !selectNode {
+		return "", nil
+	}
+
+	nodes, err := kubectlGetWorkerNodes()
+	if err != nil {
+		return "", fmt.Errorf("get node names: %w", err)
+	}
+	selectedNodeIndex, err := InteractiveSelect(nodes, func(item Node) string {
+		return fmt.Sprintf("%s  (%s)  %s", item.Name, item.Version, FormatDuration(item.Age))
+	})
+	if err != nil {
+		return "", fmt.Errorf("interactive node selection failed: %w", err)
+	}
+	return nodes[selectedNodeIndex].Name, nil
+}
+
+func getNodeAffinityLabels(nodeName string) (map[string]string, error) {
+	if len(nodeName) == 0 {
+		return nil, nil
+	}
+
+	labels, err := kubectlGetNodeLabels(nodeName, map[string]bool{
+		"beta.kubernetes.io/arch":          true,
+		"beta.kubernetes.io/os":            true,
+		"beta.kubernetes.io/instance-type": true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get node labels for node %q: %w", nodeName, err)
+	}
+
+	//TODO check set of labels is unique
+	return labels, nil
+}
+
+func printDryRunManifest(manifestData string) {
+	fmt.Println("dry-run: print manifest instead of applying it")
+	fmt.Println("###############################")
+	fmt.Println(strings.TrimSpace(manifestData))
+	fmt.Println("###############################")
+}
+
+func runTestpod(podName, manifestData string, tpl Template, f func() error) (err error) {
+	if err := kubectlApply(manifestData); err != nil {
+		var quotaErr *QuotaError
+		if errors.As(err, &quotaErr) {
+			printQuotaHeadroom(quotaErr.QuotaName)
+		}
+		return fmt.Errorf("apply manifest: %w", err)
+	}
+	defer func() {
+		start := time.Now()
+		deleteErr := kubectlDeletePod(podName)
+		if deleteErr != nil {
+			fmt.Fprintln(infoOut, "WARN: failed to delete Pod")
+			if err == nil {
+				err = cleanupWarning(fmt.Errorf("delete Pod: %w", deleteErr))
+			}
+		}
+		auditPodDeleted(podName, start, deleteErr)
+	}()
+	// always clean up the NetworkPolicy as it might also be created later on by netpol explain
+	defer func() {
+		if deleteErr := kubectlDeleteNetworkPolicy(podName); deleteErr != nil {
+			fmt.Fprintln(infoOut, "WARN: failed to delete NetworkPolicy")
+			if err == nil {
+				err = cleanupWarning(fmt.Errorf("delete NetworkPolicy: %w", deleteErr))
+			}
+		}
+	}()
+
+	if len(tpl.Pod.AgentBinary) > 0 {
+		if err := injectAgent(podName, tpl.Pod.AgentBinary); err != nil {
+			return fmt.Errorf("inject agent: %w", err)
+		}
+	}
+	if err := kubectlWaitForPod(podName); err != nil {
+		return fmt.Errorf("wait for Pod: %w", err)
+	}
+	auditPod(podName)
+
+	return f()
+}
+
+func runTestpodOnNodes(params RunParameters, tpl Template, usedOptions []string, managedBy, basePodName string) error {
+	if len(cli.Run.Command) == 0 {
+		return usageErrorf("--all-nodes and --nodes require a command after --")
+	}
+	if len(params.Node) > 0 || cli.Run.SelectNode || len(params.Like) > 0 || isTrue(params.EphemeralNamespace) {
+		return usageErrorf("cannot combine --all-nodes or --nodes with --node, --select-node, --like or --ephemeral-namespace")
+	}
+	if cli.Run.Parallel < 1 {
+		return usageErrorf("--parallel must be at least 1")
+	}
+
+	nodes, err := kubectlGetWorkerNodes()
+	if err != nil {
+		return fmt.Errorf("get worker nodes: %w", err)
+	}
+	nodes, err = SelectNodes(nodes, params.Nodes)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no worker nodes found")
+	}
+
+	guardrail, err := checkGuardrails(append(usedOptions, OptionNode), cli.Run.Reason)
+	if err != nil {
+		return err
+	}
+	auditRunParameters(params, "")
+
+	podNames, manifests, nodeTemplates, err := renderNodeTestpodManifests(managedBy, basePodName, nodes, tpl, isTrue(params.Agent), cli.Run.Reason, nil)
+	if err != nil {
+		return err
+	}
+
+	if cli.Run.DryRun {
+		printDryRunManifest(strings.Join(manifests, "\n---\n"))
+		return nil
+	}
+	if guardrail != nil {
+		if err := confirmGuardrail(guardrail); err != nil {
+			return err
+		}
+	}
+	if tpl.Pod.UsesHostNamespaces() {
+		if err := confirmHostNamespaces(tpl.Pod, fmt.Sprintf("%d nodes", len(nodes))); err != nil {
+			return err
+		}
+	}
+
+	results := make([]NodeResult, len(nodes))
+	sem := make(chan struct{}, cli.Run.Parallel)
+	var wg sync.WaitGroup
+	for i := range nodes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i].Node = nodes[i].Name
+			// runTestpod always cleans up, even if the pod cannot be scheduled
+			results[i].Err = runTestpod(podNames[i], manifests[i], nodeTemplates[i], func() error {
+				out, exitCode, err := kubectlExecGetOutputAndExitCode(podNames[i], cli.Run.Command...)
+				results[i].Output = out
+				results[i].ExitCode = exitCode
+				if err != nil {
+					return fmt.Errorf("exec into Pod: %w: %s", err, strings.TrimSpace(out))
+				}
+				return nil
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if err := WriteNodeResults(os.Stdout, results); err != nil {
+		return err
+	}
+	return NodeResultsError(results)
+}
+
+func execCmdEnter() error {
+	tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+		Shell: cli.Enter.OverrideShell,
+	})
+	if err != nil {
+		return fmt.Errorf("read template: %w", err)
+	}
+
+	podName, err := findRunningTestpod(cli.Enter.Mine)
+	if err != nil {
+		return err
+	}
+
+	if cli.Enter.DryRun {
+		fmt.Fprintln(infoOut, "dry-run: skip entering pod", podName)
+		return nil
+	}
+
+	auditPod(podName)
+	fmt.Fprintln(infoOut, "enter running pod", podName)
+	if err := kubectlExec(podName, tpl.DefaultShell); err != nil {
+		return fmt.Errorf("exec into Pod: %w", err)
+	}
+	return nil
+}
+
+func execCmdExec() error {
+	return withKubeConfig(cli.Exec.NoTempKubeConfig, func() error {
+		command := cli.Exec.Command
+		if len(command) == 0 {
+			tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+				Shell: cli.Exec.OverrideShell,
+			})
+			if err != nil {
+				return fmt.Errorf("read template: %w", err)
+			}
+			command = []string{tpl.DefaultShell}
+		}
+
+		podName := cli.Exec.Pod
+		if len(podName) == 0 {
+			var err error
+			podName, err = findRunningTestpod(cli.Exec.Mine)
+			if err != nil {
+				return err
+			}
+		}
+
+		auditPod(podName)
+		if err := kubectlExec(podName, command...); err != nil {
+			return fmt.Errorf("exec into Pod: %w", err)
+		}
+		return nil
+	})
+}
+
+// jobExitCodeTimeout is the time to wait for the exit code after the logs ended
+const jobExitCodeTimeout = 30 * time.Second
+
+// validateJobTTL keeps the job long enough to read its exit code
+func validateJobTTL(ttl time.Duration) error {
+	if ttl < jobExitCodeTimeout {
+		return usageErrorf("--ttl must be at least %s, so the exit code can be read before the job is deleted", jobExitCodeTimeout)
+	}
+	return nil
+}
+
+func execCmdJob() error {
+	return withKubeConfig(cli.Job.NoTempKubeConfig, func() (err error) {
+		if err := validateJobTTL(cli.Job.TTL); err != nil {
+			return err
+		}
+		additionalPodLabels, err := parseLabelFlags(cli.Job.Labels)
+		if err != nil {
+			return err
+		}
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image:               cli.Job.OverrideImage,
+			AdditionalPodLabels: additionalPodLabels,
+			Security:            cli.Job.Security,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		privilegedPreset := tpl.Pod.Security == SecurityPresetPrivileged
+		if len(tpl.Pod.Security) == 0 {
+			tpl.Pod.Security = detectSecurityPreset()
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		jobName := makePodName(hostname, time.Now())
+
+		nodeLabels, err := getNodeAffinityLabels(cli.Job.Node)
+		if err != nil {
+			return err
+		}
+		usedOptions := GetUsedOptions(tpl.Pod)
+		if privilegedPreset {
+			usedOptions = append(usedOptions, OptionPrivileged)
+		}
+		if len(cli.Job.Node) > 0 {
+			usedOptions = append(usedOptions, OptionNode)
+		}
+		guardrail, err := checkGuardrails(usedOptions, cli.Job.Reason)
+		if err != nil {
+			return err
+		}
+
+		jobManifest, err := MakeJobManifestFromTemplate(managedBy, jobName, nodeLabels, tpl, cli.Job.Command, cli.Job.TTL)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+		if len(cli.Job.Reason) > 0 {
+			jobManifest.Metadata.SetAnnotation(ReasonAnnotation, cli.Job.Reason)
+			jobManifest.Spec.Template.Metadata.SetAnnotation(ReasonAnnotation, cli.Job.Reason)
+		}
+		networkPolicyManifest, err := MakeNetworkPolicyManifestFromTemplate(managedBy, jobName, tpl)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+		manifestData, err := MarshalManifests(jobManifest, networkPolicyManifest)
+		if err != nil {
+			return fmt.Errorf("render manifest: %w", err)
+		}
+
+		if cli.Job.DryRun {
+			printDryRunManifest(manifestData)
+			return nil
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		if err := kubectlApply(manifestData); err != nil {
+			var quotaErr *QuotaError
+			if errors.As(err, &quotaErr) {
+				printQuotaHeadroom(quotaErr.QuotaName)
+			}
+			return fmt.Errorf("apply manifest: %w", err)
+		}
+		defer func() {
+			if deleteErr := kubectlDeleteJob(jobName); deleteErr != nil {
+				fmt.Fprintln(infoOut, "WARN: failed to delete Job")
+				if err == nil {
+					err = cleanupWarning(fmt.Errorf("delete Job: %w", deleteErr))
+				}
+			}
+		}()
+		defer func() {
+			if deleteErr := kubectlDeleteNetworkPolicy(jobName); deleteErr != nil {
+				fmt.Fprintln(infoOut, "WARN: failed to delete NetworkPolicy")
+				if err == nil {
+					err = cleanupWarning(fmt.Errorf("delete NetworkPolicy: %w", deleteErr))
+				}
+			}
+		}()
+
+		status, err := kubectlWaitForJobPod(jobName, cli.Job.StartTimeout)
+		if err != nil {
+			return fmt.Errorf("wait for Job: %w", err)
+		}
+		auditPod(status.PodName)
+		if err := kubectlStreamLogs(status.PodName); err != nil {
+			return fmt.Errorf("stream logs: %w", err)
+		}
+		exitCode, err := kubectlWaitForJobExitCode(jobName, jobExitCodeTimeout)
+		if err != nil {
+			return fmt.Errorf("wait for Job: %w", err)
+		}
+		if exitCode != 0 {
+			return &RemoteExitError{ExitCode: exitCode}
+		}
+		return nil
+	})
+}
+
+func findRunningTestpod(mine bool) (string, error) {
+	matchLabels := map[string]string{
+		"app.kubernetes.io/name": "go-testpod",
+	}
+	if mine {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("get hostname: %w", err)
+		}
+		matchLabels["app.kubernetes.io/managed-by"] = hostname
+	}
+
+	pods, err := kubectlGetPodNames(matchLabels)
+	if err != nil {
+		return "", fmt.Errorf("list running pods: %w", err)
+	}
+	if len(pods) == 0 {
+		return "", fmt.Errorf("no suitable testpods running in selected context")
+	}
+	if len(pods) > 1 {
+		return "", fmt.Errorf("multiple suitable testpods running in selected context")
+	}
+	return pods[0], nil
+}
+
+func printQuotaHeadroom(quotaName string) {
+	usage, err := kubectlGetResourceQuotaUsage(quotaName)
+	if err != nil {
+		fmt.Fprintln(infoOut, "WARN: failed to get usage of ResourceQuota", quotaName+":", err)
+		return
+	}
+
+	fmt.Fprintln(infoOut, "pod was blocked by ResourceQuota", quotaName)
+	w := tabwriter.NewWriter(infoOut, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tUSED\tHARD\tREMAINING")
+	for _, u := range usage {
+		remaining := "?"
+		hard, errHard := ParseQuantity(u.Hard)
+		used, errUsed := ParseQuantity(u.Used)
+		if errHard == nil && errUsed == nil {
+			remaining = FormatQuantity(u.Resource, hard-used)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.Resource, u.Used, u.Hard, remaining)
+	}
+	w.Flush()
+}
+
+func detectSecurityPreset() string {
+	namespace, err := kubectlGetCurrentNamespace()
+	if err != nil {
+		fmt.Fprintln(infoOut, "WARN: failed to get current namespace:", err)
+		return SecurityPresetPrivileged
+	}
+	labels, err := kubectlGetNamespaceLabels(namespace)
+	if err != nil {
+		fmt.Fprintln(infoOut, "WARN: failed to get pod security level of namespace", namespace+":", err)
+		return SecurityPresetPrivileged
+	}
+
+	level := labels["pod-security.kubernetes.io/enforce"]
+	switch level {
+	case SecurityPresetRestricted, SecurityPresetBaseline:
+		fmt.Fprintln(infoOut, "namespace", namespace, "enforces pod security level", level)
+		return level
+	default:
+		return SecurityPresetPrivileged
+	}
+}
+
+func confirmHostNamespaces(pod PodTemplate, nodeName string) error {
+	namespaces := make([]string, 0, 3)
+	if pod.HostNetwork {
+		namespaces = append(namespaces, "network")
+	}
+	if pod.HostPID {
+		namespaces = append(namespaces, "pid")
+	}
+	if pod.HostIPC {
+		namespaces = append(namespaces, "ipc")
+	}
+	if len(nodeName) == 0 {
+		nodeName = "the node it is scheduled on"
+	}
+
+	fmt.Fprintln(infoOut, "#################################################################")
+	fmt.Fprintln(infoOut, "WARNING: the testpod will share the host", strings.Join(namespaces, ", "), "namespaces")
+	fmt.Fprintln(infoOut, "         it can see and interfere with everything on", nodeName)
+	fmt.Fprintln(infoOut, "#################################################################")
+	ok, err := InteractiveConfirm("Run testpod in host namespaces")
+	if err != nil {
+		return fmt.Errorf("confirm host namespaces: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("aborted by user")
+	}
+	return nil
+}
+
+func execCmdHistory() error {
+	filter := AuditFilter{
+		Context:   cli.Context,
+		Namespace: cli.Namespace,
+		Image:     cli.History.Image,
+	}
+	var err error
+	if len(cli.History.Since) > 0 {
+		if filter.Since, err = ParseAuditDate(cli.History.Since, false); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+	}
+	if len(cli.History.Until) > 0 {
+		if filter.Until, err = ParseAuditDate(cli.History.Until, true); err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+	}
+
+	records, err := readAuditLog()
+	if err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	matching := make([]AuditRecord, 0)
+	for _, r := range records {
+		if filter.Matches(r) {
+			matching = append(matching, r)
+		}
+	}
+
+	if cli.History.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range matching {
+			if err := enc.Encode(&r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return WriteAuditRecords(os.Stdout, matching)
+}
+
+func execCmdNetcheckMatrix() error {
+	return withKubeConfig(cli.Netcheck.Matrix.NoTempKubeConfig, func() error {
+		if cli.Netcheck.Matrix.Parallel < 1 {
+			return usageErrorf("--parallel must be at least 1")
+		}
+		if cli.Netcheck.Matrix.Port < 1 || cli.Netcheck.Matrix.Port > 65535 {
+			return usageErrorf("invalid port %d", cli.Netcheck.Matrix.Port)
+		}
+		if cli.Netcheck.Matrix.Timeout <= 0 {
+			return usageErrorf("--timeout must be positive")
+		}
+
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image: cli.Netcheck.Matrix.OverrideImage,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		if len(tpl.Pod.Security) == 0 {
+			tpl.Pod.Security = detectSecurityPreset()
+		}
+
+		nodes, err := kubectlGetWorkerNodes()
+		if err != nil {
+			return fmt.Errorf("get worker nodes: %w", err)
+		}
+		nodes, err = SelectNodes(nodes, cli.Netcheck.Matrix.Nodes)
+		if err != nil {
+			return err
+		}
+		if cli.Netcheck.Matrix.PerZone {
+			zones := make(map[string]string)
+			for _, n := range nodes {
+				labels, err := kubectlGetNodeLabels(n.Name, nil)
+				if err != nil {
+					return fmt.Errorf("get labels of node %q: %w", n.Name, err)
+				}
+				if zone, ok := labels[ZoneLabel]; ok {
+					zones[n.Name] = zone
+				}
+			}
+			nodes = SelectNodesPerZone(nodes, zones)
+		}
+		if len(nodes) < 2 {
+			return fmt.Errorf("found %d nodes to check, at least 2 are required", len(nodes))
+		}
+
+		guardrail, err := checkGuardrails(append(GetUsedOptions(tpl.Pod), OptionNode), cli.Netcheck.Matrix.Reason)
+		if err != nil {
+			return err
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		basePodName := makePodName(hostname, time.Now())
+
+		protocol := cli.Netcheck.Matrix.Protocol
+		port := cli.Netcheck.Matrix.Port
+		tpl.Pod.AdditionalLabels[NetcheckLabel] = basePodName
+		if tpl.NetworkPolicy.Enabled() {
+			// the testpods need to reach each other in addition to the rules of the template
+			rule := NetworkPolicyRuleTemplate{
+				Ports:       []NetworkPolicyPortTemplate{{Protocol: protocol, Port: port}},
+				PodSelector: map[string]string{NetcheckLabel: basePodName},
+			}
+			tpl.NetworkPolicy.Egress = append(tpl.NetworkPolicy.Egress, rule)
+			tpl.NetworkPolicy.Ingress = append(tpl.NetworkPolicy.Ingress, rule)
+		}
+
+		podNames, manifests, nodeTemplates, err := renderNodeTestpodManifests(managedBy, basePodName, nodes, tpl, true, cli.Netcheck.Matrix.Reason, func(i int, nodeTpl *Template) {
+			nodeTpl.Pod.Command = MakeNetcheckListenCommand(protocol, port)
+		})
+		if err != nil {
+			return err
+		}
+
+		if cli.Netcheck.Matrix.DryRun {
+			printDryRunManifest(strings.Join(manifests, "\n---\n"))
+			return nil
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		// every testpod is kept running until all probes are done
+		sem := make(chan struct{}, cli.Netcheck.Matrix.Parallel)
+		done := make(chan struct{})
+		podIPs := make([]string, len(nodes))
+		podErrs := make([]error, len(nodes))
+		var startWg, cleanupWg sync.WaitGroup
+		for i := range nodes {
+			startWg.Add(1)
+			cleanupWg.Add(1)
+			go func(i int) {
+				defer cleanupWg.Done()
+				// the slot is released as soon as the testpod runs, as it is blocked until all probes are done
+				sem <- struct{}{}
+				started := false
+				err := runTestpod(podNames[i], manifests[i], nodeTemplates[i], func() error {
+					ip, err := kubectlGetPodIP(podNames[i])
+					if err != nil {
+						return fmt.Errorf("get pod ip: %w", err)
+					}
+					podIPs[i] = ip
+					started = true
+					<-sem
+					startWg.Done()
+					<-done
+					return nil
+				})
+				if !started {
+					<-sem
+					podErrs[i] = err
+					startWg.Done()
+				} else if err != nil {
+					// cleanup warnings are reported after the matrix
+					podErrs[i] = err
+				}
+			}(i)
+		}
+		startWg.Wait()
+
+		matrix := ConnectivityMatrix{Protocol: protocol, Port: port}
+		for _, n := range nodes {
+			matrix.Nodes = append(matrix.Nodes, n.Name)
+		}
+		var resultsMutex sync.Mutex
+		var probeWg sync.WaitGroup
+		for i := range nodes {
+			probeWg.Add(1)
+			go func(i int) {
+				defer probeWg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				targets := make([]string, 0, len(nodes))
+				for j := range nodes {
+					if j != i && len(podIPs[j]) > 0 {
+						targets = append(targets, podIPs[j])
+					}
+				}
+				var probeResults map[string]ConnectivityResult
+				var probeErr error
+				if podErrs[i] != nil {
+					probeErr = fmt.Errorf("testpod did not start")
+				} else if len(targets) > 0 {
+					out, err := kubectlExecGetStdout(podNames[i], MakeNetcheckProbeCommand(protocol, port, cli.Netcheck.Matrix.Timeout, targets)...)
+					if err != nil {
+						probeErr = err
+					} else {
+						probeResults, probeErr = ParseNetcheckProbeResults(out)
+					}
+				}
+
+				resultsMutex.Lock()
+				defer resultsMutex.Unlock()
+				for j := range nodes {
+					if j == i {
+						continue
+					}
+					result := ConnectivityResult{LatencyMillis: -1}
+					if r, ok := probeResults[podIPs[j]]; ok && len(podIPs[j]) > 0 {
+						result = r
+					} else if probeErr != nil {
+						result.Error = probeErr.Error()
+						result.Skipped = true
+					} else {
+						result.Error = "testpod did not start"
+						result.Skipped = true
+					}
+					result.From = nodes[i].Name
+					result.To = nodes[j].Name
+					matrix.Results = append(matrix.Results, result)
+				}
+			}(i)
+		}
+		probeWg.Wait()
+		close(done)
+		cleanupWg.Wait()
+
+		sort.Slice(matrix.Results, func(i, j int) bool {
+			if matrix.Results[i].From != matrix.Results[j].From {
+				return matrix.Results[i].From < matrix.Results[j].From
+			}
+			return matrix.Results[i].To < matrix.Results[j].To
+		})
+		if cli.Netcheck.Matrix.JSON {
+			if err := matrix.WriteJSON(os.Stdout); err != nil {
+				return err
+			}
+		} else {
+			if err := matrix.Write(os.Stdout); err != nil {
+				return err
+			}
+		}
+
+		results := make([]NodeResult, len(nodes))
+		for i, n := range nodes {
+			results[i] = NodeResult{Node: n.Name, Err: podErrs[i]}
+		}
+		if err := NodeResultsError(results); err != nil {
+			return err
+		}
+		if failures := matrix.Failures(); failures > 0 {
+			return fmt.Errorf("%d of %d connections failed", failures, len(matrix.Results))
+		}
+		return nil
+	})
+}
+
+func execCmdProbe() error {
+	return withKubeConfig(cli.Probe.NoTempKubeConfig, func() error {
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image: cli.Probe.OverrideImage,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		if len(tpl.Pod.Security) == 0 {
+			tpl.Pod.Security = detectSecurityPreset()
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		podName := makePodName(hostname, time.Now())
+
+		nodeLabels, err := getNodeAffinityLabels(cli.Probe.Node)
+		if err != nil {
+			return err
+		}
+		if nodeLabels, err = prepareAgent(&tpl, cli.Probe.Node, nodeLabels); err != nil {
+			return err
+		}
+		usedOptions := GetUsedOptions(tpl.Pod)
+		if len(cli.Probe.Node) > 0 {
+			usedOptions = append(usedOptions, OptionNode)
+		}
+		guardrail, err := checkGuardrails(usedOptions, cli.Probe.Reason)
+		if err != nil {
+			return err
+		}
+
+		manifestData, err := renderTestpodManifest(managedBy, podName, nodeLabels, tpl, cli.Probe.Reason)
+		if err != nil {
+			return err
+		}
+
+		if cli.Probe.DryRun {
+			printDryRunManifest(manifestData)
+			return nil
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		return runTestpod(podName, manifestData, tpl, func() error {
+			out, err := kubectlExecGetStdout(podName, AgentPath, "agent", "probe", cli.Probe.Kind, cli.Probe.Target, "--timeout", cli.Probe.Timeout.String())
+			if err != nil {
+				return fmt.Errorf("run probe: %w", err)
+			}
+			var result ProbeResult
+			if err := json.Unmarshal([]byte(out), &result); err != nil {
+				return fmt.Errorf("parse probe result: %w", err)
+			}
+
+			if cli.Probe.JSON {
+				err = result.WriteJSON(os.Stdout)
+			} else {
+				err = result.Write(os.Stdout, time.Now())
+			}
+			if err != nil {
+				return err
+			}
+			if !result.Success {
+				return fmt.Errorf("%s probe of %s failed", result.Kind, result.Target)
+			}
+			return nil
+		})
+	})
+}
+
+func execCmdAgent(cmd string) error {
+	switch cmd {
+	case "agent sleep":
+		return execAgentSleep()
+
+	case "agent shell":
+		return execAgentShell()
+
+	case "agent probe <kind> <target>":
+		return RunProbe(cli.Agent.Probe.Kind, cli.Agent.Probe.Target, cli.Agent.Probe.Timeout).WriteJSON(os.Stdout)
+
+	case "agent listen":
+		return execAgentListen(cli.Agent.Listen.Protocol, cli.Agent.Listen.Port)
+
+	case "agent reach <targets>":
+		return json.NewEncoder(os.Stdout).Encode(ProbeReachability(cli.Agent.Reach.Protocol, cli.Agent.Reach.Port, cli.Agent.Reach.Timeout, cli.Agent.Reach.Targets))
+
+	case "agent bench-server":
+		addr, closer, err := ListenBench(fmt.Sprintf(":%d", cli.Agent.BenchServer.Port))
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		fmt.Fprintf(os.Stderr, "serve bench on %s\n", addr)
+		waitForTermination()
+		return nil
+
+	case "agent bench <address>":
+		return json.NewEncoder(os.Stdout).Encode(RunBench(cli.Agent.Bench.Address, BenchOptions{
+			Protocol:    cli.Agent.Bench.Protocol,
+			Duration:    cli.Agent.Bench.Duration,
+			Samples:     cli.Agent.Bench.Samples,
+			UDPRateMbps: cli.Agent.Bench.UDPRate,
+			ConnectOnly: cli.Agent.Bench.ConnectOnly,
+		}))
+
+	case "agent get <path>":
+		return execAgentGet(cli.Agent.Get.Path)
+
+	case "agent put <path>":
+		return execAgentPut(cli.Agent.Put.Path)
+
+	default:
+		return fmt.Errorf("unknown agent command %q", cmd)
+	}
+}
+
+// renderTestpodManifest renders the pod and its NetworkPolicy with the reason as annotation
+func renderTestpodManifest(managedBy, podName string, nodeLabels map[string]string, tpl Template, reason string) (string, error) {
+	podManifest, err := MakePodManifestFromTemplate(managedBy, podName, nodeLabels, tpl)
+	if err != nil {
+		return "", fmt.Errorf("render manifest: %w", err)
+	}
+	if len(reason) > 0 {
+		podManifest.Metadata.SetAnnotation(ReasonAnnotation, reason)
+	}
+	networkPolicyManifest, err := MakeNetworkPolicyManifestFromTemplate(managedBy, podName, tpl)
+	if err != nil {
+		return "", fmt.Errorf("render manifest: %w", err)
+	}
+	manifestData, err := MarshalManifests(podManifest, networkPolicyManifest)
+	if err != nil {
+		return "", fmt.Errorf("render manifest: %w", err)
+	}
+	return manifestData, nil
+}
+
+// renderNodeTestpodManifests pins one testpod to each node, tolerating its taints. all manifests are rendered first, so invalid templates do not leave pods behind. customize can adjust the template per pod, e.g. its command
+func renderNodeTestpodManifests(managedBy, basePodName string, nodes []Node, tpl Template, agent bool, reason string, customize func(i int, nodeTpl *Template)) ([]string, []string, []Template, error) {
+	podNames := make([]string, len(nodes))
+	manifests := make([]string, len(nodes))
+	nodeTemplates := make([]Template, len(nodes))
+	for i, node := range nodes {
+		podNames[i] = makeIndexedPodName(basePodName, i+1)
+		nodeLabels, err := getNodeAffinityLabels(node.Name)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		nodeTpl := tpl
+		nodeTpl.Pod.Tolerations = append(append([]TolerationTemplate{}, tpl.Pod.Tolerations...), MakeTolerationsForTaints(node.Taints)...)
+		if agent {
+			if nodeLabels, err = prepareAgent(&nodeTpl, node.Name, nodeLabels); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		if customize != nil {
+			customize(i, &nodeTpl)
+		}
+		nodeTemplates[i] = nodeTpl
+		if manifests[i], err = renderTestpodManifest(managedBy, podNames[i], nodeLabels, nodeTpl, reason); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return podNames, manifests, nodeTemplates, nil
+}
+
+// prepareAgent lets the pod run the agent instead of the template command and restricts it to nodes matching the agent binary
+func prepareAgent(tpl *Template, nodeName string, nodeLabels map[string]string) (map[string]string, error) {
+	nodeArch := ""
+	if len(nodeName) > 0 {
+		labels, err := kubectlGetNodeLabels(nodeName, nil)
+		if err != nil {
+			return nil, fmt.Errorf("get labels of node %q: %w", nodeName, err)
+		}
+		nodeArch = labels[ArchLabel]
+	}
+	path, arch, err := ResolveAgentBinary(cli.AgentBinary, nodeArch)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl.Pod.AgentBinary = path
+	tpl.Pod.Command = []string{AgentPath, "agent", "sleep"}
+	tpl.Pod.Args = nil
+	merged := map[string]string{OSLabel: "linux", ArchLabel: arch}
+	for k, v := range nodeLabels {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+func execCmdCp() error {
+	return withKubeConfig(cli.Cp.NoTempKubeConfig, func() error {
+		srcPod, srcPath := ParseCopyPath(cli.Cp.Source)
+		dstPod, dstPath := ParseCopyPath(cli.Cp.Destination)
+		if (len(srcPod) > 0) == (len(dstPod) > 0) {
+			return usageErrorf("exactly one of source and destination must be like pod:path")
+		}
+
+		if len(dstPod) > 0 {
+			data, err := os.ReadFile(srcPath)
+			if err != nil {
+				return fmt.Errorf("read file: %w", err)
+			}
+			if out, err := kubectlGetOutput(options{
+				Args:   []string{"exec", "-i", dstPod, "--", AgentPath, "agent", "put", dstPath},
+				Silent: true,
+				StdIn:  string(data),
+			}); err != nil {
+				return fmt.Errorf("upload file, is the testpod running with --agent?: %w: %s", err, strings.TrimSpace(out))
+			}
+			fmt.Fprintf(infoOut, "copied %d bytes to %s\n", len(data), cli.Cp.Destination)
+			return nil
+		}
+
+		f, err := os.Create(dstPath)
+		if err != nil {
+			return fmt.Errorf("create file: %w", err)
+		}
+		if out, err := kubectlGetOutput(options{
+			Args:   []string{"exec", srcPod, "--", AgentPath, "agent", "get", srcPath},
+			Stdout: f,
+		}); err != nil {
+			f.Close()
+			os.Remove(dstPath)
+			return fmt.Errorf("download file, is the testpod running with --agent?: %w: %s", err, strings.TrimSpace(out))
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+		fmt.Fprintf(infoOut, "copied %s to %s\n", cli.Cp.Source, dstPath)
+		return nil
+	})
+}
+
+func execCmdBenchNet() error {
+	return withKubeConfig(cli.Bench.Net.NoTempKubeConfig, func() error {
+		protocols, err := ParseBenchProtocols(cli.Bench.Net.Protocols)
+		if err != nil {
+			return err
+		}
+		if cli.Bench.Net.Port < 1 || cli.Bench.Net.Port > 65535 {
+			return usageErrorf("invalid port %d", cli.Bench.Net.Port)
+		}
+		if cli.Bench.Net.Duration <= 0 || cli.Bench.Net.Samples < 1 || cli.Bench.Net.UDPRate < 1 {
+			return usageErrorf("--duration, --samples and --udp-rate must be positive")
+		}
+
+		// services can only be measured with tcp handshakes, as they do not run a bench server
+		var serviceAddress string
+		nodeNames := []string{cli.Bench.Net.From}
+		if strings.Contains(cli.Bench.Net.To, "/") {
+			kind, name, port, err := ParseNetworkPolicyTarget(cli.Bench.Net.To)
+			if err != nil {
+				return err
+			}
+			if kind != "svc" {
+				return usageErrorf("--to must be a node or a Service like svc/name:port")
+			}
+			serviceAddress = net.JoinHostPort(name, strconv.Itoa(port))
+			protocols = []string{"TCP"}
+		} else {
+			if cli.Bench.Net.To == cli.Bench.Net.From {
+				return usageErrorf("--from and --to must be different nodes")
+			}
+			nodeNames = append(nodeNames, cli.Bench.Net.To)
+		}
+
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{
+			Image: cli.Bench.Net.OverrideImage,
+		})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		if len(tpl.Pod.Security) == 0 {
+			tpl.Pod.Security = detectSecurityPreset()
+		}
+
+		nodes, err := kubectlGetWorkerNodes()
+		if err != nil {
+			return fmt.Errorf("get worker nodes: %w", err)
+		}
+		nodes, err = SelectNodes(nodes, nodeNames)
+		if err != nil {
+			return err
+		}
+
+		guardrail, err := checkGuardrails(append(GetUsedOptions(tpl.Pod), OptionNode), cli.Bench.Net.Reason)
+		if err != nil {
+			return err
+		}
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		basePodName := makePodName(hostname, time.Now())
+
+		port := cli.Bench.Net.Port
+		tpl.Pod.AdditionalLabels[BenchLabel] = basePodName
+		if tpl.NetworkPolicy.Enabled() && len(serviceAddress) == 0 {
+			// sender and receiver need to reach each other in addition to the rules of the template
+			for _, protocol := range protocols {
+				rule := NetworkPolicyRuleTemplate{
+					Ports:       []NetworkPolicyPortTemplate{{Protocol: protocol, Port: port}},
+					PodSelector: map[string]string{BenchLabel: basePodName},
+				}
+				tpl.NetworkPolicy.Egress = append(tpl.NetworkPolicy.Egress, rule)
+				tpl.NetworkPolicy.Ingress = append(tpl.NetworkPolicy.Ingress, rule)
+			}
+		}
+
+		// the first pod sends, the optional second pod receives
+		podNames, manifests, nodeTemplates, err := renderNodeTestpodManifests(managedBy, basePodName, nodes, tpl, true, cli.Bench.Net.Reason, func(i int, nodeTpl *Template) {
+			if i == 1 {
+				nodeTpl.Pod.Command = []string{AgentPath, "agent", "bench-server", "--port", strconv.Itoa(port)}
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if cli.Bench.Net.DryRun {
+			printDryRunManifest(strings.Join(manifests, "\n---\n"))
+			return nil
+		}
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		var results []BenchResult
+		runSender := func(address string) error {
+			return runTestpod(podNames[0], manifests[0], nodeTemplates[0], func() error {
+				for _, protocol := range protocols {
+					fmt.Fprintf(infoOut, "measure %s from %s to %s\n", protocol, cli.Bench.Net.From, cli.Bench.Net.To)
+					args := []string{AgentPath, "agent", "bench", address, "--protocol", protocol,
+						"--duration", cli.Bench.Net.Duration.String(), "--samples", strconv.Itoa(cli.Bench.Net.Samples),
+						"--udp-rate", strconv.Itoa(cli.Bench.Net.UDPRate)}
+					if len(serviceAddress) > 0 {
+						args = append(args, "--connect-only")
+					}
+					result, err := RunBenchInPod(podNames[0], args)
+					if err != nil {
+						return err
+					}
+					result.From = cli.Bench.Net.From
+					result.To = cli.Bench.Net.To
+					results = append(results, result)
+				}
+				return nil
+			})
+		}
+		if len(serviceAddress) > 0 {
+			err = runSender(serviceAddress)
+		} else {
+			// the receiver is kept running until the sender is done and both are always cleaned up
+			err = runTestpod(podNames[1], manifests[1], nodeTemplates[1], func() error {
+				ip, err := kubectlGetPodIP(podNames[1])
+				if err != nil {
+					return fmt.Errorf("get pod ip: %w", err)
+				}
+				return runSender(net.JoinHostPort(ip, strconv.Itoa(port)))
+			})
+		}
+		if len(results) > 0 {
+			var writeErr error
+			if cli.Bench.Net.JSON {
+				writeErr = WriteBenchResultsJSON(os.Stdout, results)
+			} else {
+				writeErr = WriteBenchResults(os.Stdout, results)
+			}
+			if writeErr != nil && err == nil {
+				err = writeErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			if len(r.Error) > 0 {
+				return fmt.Errorf("%s benchmark failed: %s", r.Protocol, r.Error)
+			}
+		}
+		return nil
+	})
+}
+
+func execCmdCapture() error {
+	kind, name, err := ParseCaptureTarget(cli.Capture.Target)
+	if err != nil {
+		return err
+	}
+
+	return withKubeConfig(cli.Capture.NoTempKubeConfig, func() (err error) {
+		tpl, err := ReadTemplateWithOverrides(TemplateOverrides{})
+		if err != nil {
+			return fmt.Errorf("read template: %w", err)
+		}
+		if len(cli.Capture.OverrideImage) > 0 {
+			tpl.CaptureImage = cli.Capture.OverrideImage
+		} else if len(tpl.CaptureImage) == 0 {
+			tpl.CaptureImage = DefaultCaptureImage
+		}
+		tpl.DefaultImage = tpl.CaptureImage
+
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("get hostname: %w", err)
+		}
+		managedBy := hostname
+		podName := makePodName(hostname, time.Now())
+
+		var manifestData string
+		usedOptions := []string{OptionCapture, OptionCapabilities}
+		if kind == "node" {
+			nodeLabels, err := getNodeAffinityLabels(name)
+			if err != nil {
+				return err
+			}
+			taints, err := kubectlGetNodeTaints(name)
+			if err != nil {
+				return fmt.Errorf("get taints of node %q: %w", name, err)
+			}
+
+			// host networking is only allowed by the privileged pod security standard, but the capabilities suffice for tcpdump
+			tpl.Pod.Security = SecurityPresetPrivileged
+			tpl.Pod.HostNetwork = true
+			tpl.Pod.Capabilities = []string{"NET_ADMIN", "NET_RAW"}
+			tpl.Pod.Command = []string{"sleep"}
+			tpl.Pod.Args = []string{"infinity"}
+			tpl.Pod.Tolerations = append(tpl.Pod.Tolerations, MakeTolerationsForTaints(taints)...)
+			usedOptions = append(GetUsedOptions(tpl.Pod), OptionCapture, OptionNode)
+
+			manifestData, err = renderTestpodManifest(managedBy, podName, nodeLabels, tpl, cli.Capture.Reason)
+			if err != nil {
+				return err
+			}
+		}
+
+		guardrail, err := checkGuardrails(usedOptions, cli.Capture.Reason)
+		if err != nil {
+			return err
+		}
+
+		if cli.Capture.DryRun {
+			if kind == "node" {
+				printDryRunManifest(manifestData)
+			} else {
+				fmt.Fprintln(infoOut, "add ephemeral container", podName, "with image", tpl.CaptureImage, "and profile netadmin to pod", name)
+			}
+			return nil
+		}
+
+		if guardrail != nil {
+			if err := confirmGuardrail(guardrail); err != nil {
+				return err
+			}
+		}
+
+		out := os.Stdout
+		if cli.Capture.Write != "-" {
+			if out, err = os.Create(cli.Capture.Write); err != nil {
+				return fmt.Errorf("create capture file: %w", err)
+			}
+		}
+		defer func() {
+			if out != os.Stdout {
+				if closeErr := out.Close(); closeErr != nil && err == nil {
+					err = fmt.Errorf("close capture file: %w", closeErr)
+				}
+			}
+		}()
+
+		// ctrl+c stops kubectl and thereby tcpdump, but must not interrupt the cleanup
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupts)
+
+		tcpdumpCommand := MakeTcpdumpCommand(cli.Capture.Interface, cli.Capture.Filter, cli.Capture.Count)
+		stream := func(podName, containerName string) error {
+			fmt.Fprintln(infoOut, "capture packets, press ctrl+c to stop")
+			if err := kubectlExecStream(podName, containerName, out, tcpdumpCommand...); err != nil {
+				// kubectl might exit before the interrupt arrives here
+				select {
+				case <-interrupts:
+					return nil
+				case <-time.After(time.Second):
+					return fmt.Errorf("run tcpdump: %w", err)
+				}
+			}
+			return nil
+		}
+
+		if kind == "node" {
+			return runTestpod(podName, manifestData, tpl, func() error {
+				return stream(podName, "")
+			})
+		}
+
+		auditPod(name)
+		if auditSession != nil {
+			auditSession.Image = tpl.CaptureImage
+		}
+		fmt.Fprintln(infoOut, "add ephemeral container", podName, "to pod", name)
+		if err := kubectlDebugDetached(name, podName, tpl.CaptureImage, "netadmin", MakeCaptureSleepCommand(24*time.Hour)...); err != nil {
+			return fmt.Errorf("debug Pod: %w", err)
+		}
+		defer func() {
+			if stopOut, stopErr := kubectlExecContainerGetOutput(name, podName, MakeCaptureStopCommand()...); stopErr != nil && err == nil {
+				err = cleanupWarning(fmt.Errorf("stop ephemeral container %s: %w: %s", podName, stopErr, strings.TrimSpace(stopOut)))
+			}
+		}()
+		if err := kubectlWaitForContainer(name, podName, 30*time.Second); err != nil {
+			return err
+		}
+		return stream(name, podName)
+	})
 }
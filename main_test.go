@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixPodAndCommandArgs(t *testing.T) {
+	pod, command := fixPodAndCommandArgs("mypod", []string{"ls", "-la"}, []string{"exec", "mypod", "--", "ls", "-la"})
+	require.Equal(t, "mypod", pod)
+	require.Equal(t, []string{"ls", "-la"}, command)
+
+	// kong assigns the first command arg to the pod if it is only given after --
+	pod, command = fixPodAndCommandArgs("ls", []string{"-la"}, []string{"exec", "--mine", "--", "ls", "-la"})
+	require.Equal(t, "", pod)
+	require.Equal(t, []string{"ls", "-la"}, command)
+
+	pod, command = fixPodAndCommandArgs("sh", []string{"-c", "echo -- done"}, []string{"exec", "--", "sh", "-c", "echo -- done"})
+	require.Equal(t, "", pod)
+	require.Equal(t, []string{"sh", "-c", "echo -- done"}, command)
+
+	pod, command = fixPodAndCommandArgs("mypod", nil, []string{"exec", "mypod"})
+	require.Equal(t, "mypod", pod)
+	require.Empty(t, command)
+}
+
+func TestValidateJobTTL(t *testing.T) {
+	require.NoError(t, validateJobTTL(10*time.Minute))
+	require.NoError(t, validateJobTTL(jobExitCodeTimeout))
+	for _, ttl := range []time.Duration{0, time.Second, -time.Minute} {
+		err := validateJobTTL(ttl)
+		require.Error(t, err)
+		require.Equal(t, ExitCodeUsageError, getExitCode(err))
+	}
+}
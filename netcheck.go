@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	NetcheckLabel = "testpod.io/netcheck"
+	ZoneLabel     = "topology.kubernetes.io/zone"
+)
+
+type ConnectivityMatrix struct {
+	Protocol string               `json:"protocol"`
+	Port     int                  `json:"port"`
+	Nodes    []string             `json:"nodes"`
+	Results  []ConnectivityResult `json:"results"`
+}
+
+type ConnectivityResult struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Reachable bool   `json:"reachable"`
+	// LatencyMillis is negative if the latency could not be measured
+	LatencyMillis float64 `json:"latencyMillis"`
+	Error         string  `json:"error,omitempty"`
+	// Skipped is set if the connection could not be probed, e.g. because a testpod did not start
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func MakeNetcheckListenCommand(protocol string, port int) []string {
+	return []string{AgentPath, "agent", "listen", "--protocol", protocol, "--port", strconv.Itoa(port)}
+}
+
+func MakeNetcheckProbeCommand(protocol string, port int, timeout time.Duration, targets []string) []string {
+	return append([]string{AgentPath, "agent", "reach", "--protocol", protocol, "--port", strconv.Itoa(port), "--timeout", timeout.String(), "--"}, targets...)
+}
+
+// ProbeReachability is run by the agent and expects echo listeners on the targets. udp has no connection to check, so the echo of a datagram is awaited instead
+func ProbeReachability(protocol string, port int, timeout time.Duration, targets []string) []ConnectivityResult {
+	results := make([]ConnectivityResult, 0, len(targets))
+	for _, target := range targets {
+		result := ConnectivityResult{To: target, LatencyMillis: -1}
+		start := time.Now()
+		if err := probeEcho(protocol, net.JoinHostPort(target, strconv.Itoa(port)), timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+			result.LatencyMillis = millisSince(start)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func probeEcho(protocol, address string, timeout time.Duration) error {
+	if protocol != "UDP" {
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write([]byte("netcheck")); err != nil {
+		return err
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != "netcheck" {
+		return fmt.Errorf("unexpected response %q", buf[:n])
+	}
+	return nil
+}
+
+func ParseNetcheckProbeResults(out string) (map[string]ConnectivityResult, error) {
+	var results []ConnectivityResult
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		return nil, fmt.Errorf("parse probe results: %w", err)
+	}
+	resultsByTarget := make(map[string]ConnectivityResult, len(results))
+	for _, r := range results {
+		resultsByTarget[r.To] = r
+	}
+	return resultsByTarget, nil
+}
+
+func (m ConnectivityMatrix) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&m)
+}
+
+func (m ConnectivityMatrix) Write(w io.Writer) error {
+	results := make(map[string]ConnectivityResult, len(m.Results))
+	for _, r := range m.Results {
+		results[r.From+"\x00"+r.To] = r
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"FROM \\ TO"}
+	for i := range m.Nodes {
+		header = append(header, strconv.Itoa(i+1))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for i, from := range m.Nodes {
+		row := []string{fmt.Sprintf("%d %s", i+1, from)}
+		for _, to := range m.Nodes {
+			if from == to {
+				row = append(row, "-")
+				continue
+			}
+			r, ok := results[from+"\x00"+to]
+			switch {
+			case !ok || r.Skipped:
+				row = append(row, "n/a")
+			case !r.Reachable:
+				row = append(row, "FAIL")
+			case r.LatencyMillis < 0:
+				row = append(row, "ok")
+			default:
+				row = append(row, fmt.Sprintf("%.1fms", r.LatencyMillis))
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (m ConnectivityMatrix) Failures() int {
+	failures := 0
+	for _, r := range m.Results {
+		if !r.Reachable {
+			failures++
+		}
+	}
+	return failures
+}
+
+// SelectNodesPerZone keeps the first node of every zone. nodes without zone label are dropped
+func SelectNodesPerZone(nodes []Node, zones map[string]string) []Node {
+	seen := make(map[string]bool)
+	selected := make([]Node, 0)
+	for _, n := range nodes {
+		zone, ok := zones[n.Name]
+		if !ok || seen[zone] {
+			continue
+		}
+		seen[zone] = true
+		selected = append(selected, n)
+	}
+	return selected
+}
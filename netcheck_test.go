@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeReachability(t *testing.T) {
+	for _, protocol := range []string{"TCP", "UDP"} {
+		addr, closer, err := ListenEcho(protocol, "127.0.0.1:0")
+		require.NoError(t, err)
+		_, portStr, err := net.SplitHostPort(addr.String())
+		require.NoError(t, err)
+		port, err := strconv.Atoi(portStr)
+		require.NoError(t, err)
+
+		results := ProbeReachability(protocol, port, time.Second, []string{"127.0.0.1"})
+		require.Len(t, results, 1)
+		require.True(t, results[0].Reachable, results[0].Error)
+		require.Equal(t, "127.0.0.1", results[0].To)
+		require.GreaterOrEqual(t, results[0].LatencyMillis, 0.0)
+
+		require.NoError(t, closer.Close())
+		results = ProbeReachability(protocol, port, 100*time.Millisecond, []string{"127.0.0.1"})
+		require.False(t, results[0].Reachable)
+		require.NotEmpty(t, results[0].Error)
+	}
+}
+
+func TestParseNetcheckProbeResults(t *testing.T) {
+	results, err := ParseNetcheckProbeResults(`[{"from":"","to":"10.0.0.2","reachable":true,"latencyMillis":0.5},{"from":"","to":"10.0.0.3","reachable":false,"latencyMillis":-1,"error":"i/o timeout"}]`)
+	require.NoError(t, err)
+	require.Equal(t, map[string]ConnectivityResult{
+		"10.0.0.2": {To: "10.0.0.2", Reachable: true, LatencyMillis: 0.5},
+		"10.0.0.3": {To: "10.0.0.3", LatencyMillis: -1, Error: "i/o timeout"},
+	}, results)
+
+	_, err = ParseNetcheckProbeResults("exec: not found")
+	require.Error(t, err)
+}
+
+func TestWriteConnectivityMatrix(t *testing.T) {
+	matrix := ConnectivityMatrix{
+		Protocol: "TCP",
+		Port:     7777,
+		Nodes:    []string{"worker-1", "worker-2"},
+		Results: []ConnectivityResult{
+			{From: "worker-1", To: "worker-2", Reachable: true, LatencyMillis: 1.25},
+			{From: "worker-2", To: "worker-1", Reachable: false, LatencyMillis: -1},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, matrix.Write(&buf))
+	require.Equal(t, "FROM \\ TO   1     2\n1 worker-1  -     1.2ms\n2 worker-2  FAIL  -\n", buf.String())
+	require.Equal(t, 1, matrix.Failures())
+}
+
+func TestSelectNodesPerZone(t *testing.T) {
+	nodes := []Node{{Name: "worker-1"}, {Name: "worker-2"}, {Name: "worker-3"}, {Name: "worker-4"}}
+	zones := map[string]string{"worker-1": "a", "worker-2": "a", "worker-3": "b"}
+	require.Equal(t, []Node{{Name: "worker-1"}, {Name: "worker-3"}}, SelectNodesPerZone(nodes, zones))
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type NetworkPolicy struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		PodSelector LabelSelector       `json:"podSelector"`
+		PolicyTypes []string            `json:"policyTypes"`
+		Ingress     []NetworkPolicyRule `json:"ingress"`
+		Egress      []NetworkPolicyRule `json:"egress"`
+	} `json:"spec"`
+}
+
+type NetworkPolicyRule struct {
+	Ports []NetworkPolicyPort `json:"ports"`
+	From  []NetworkPolicyPeer `json:"from"`
+	To    []NetworkPolicyPeer `json:"to"`
+}
+
+type NetworkPolicyPort struct {
+	Protocol string      `json:"protocol"`
+	Port     interface{} `json:"port"`
+	EndPort  int         `json:"endPort"`
+}
+
+type NetworkPolicyPeer struct {
+	PodSelector       *LabelSelector `json:"podSelector"`
+	NamespaceSelector *LabelSelector `json:"namespaceSelector"`
+	IPBlock           *struct {
+		CIDR   string   `json:"cidr"`
+		Except []string `json:"except"`
+	} `json:"ipBlock"`
+}
+
+type LabelSelector struct {
+	MatchLabels      map[string]string `json:"matchLabels"`
+	MatchExpressions []struct {
+		Key      string   `json:"key"`
+		Operator string   `json:"operator"`
+		Values   []string `json:"values"`
+	} `json:"matchExpressions"`
+}
+
+type NetworkEndpoint struct {
+	Name            string
+	Namespace       string
+	NamespaceLabels map[string]string
+	Labels          map[string]string
+	IP              string
+	// named container ports of the pod
+	NamedPorts map[string]int
+}
+
+type NetworkPolicyVerdict struct {
+	// Isolated is true if at least one policy selects the pod for the evaluated direction
+	Isolated          bool
+	Allowed           bool
+	SelectingPolicies []string
+	AllowedBy         string
+}
+
+func (sel LabelSelector) Matches(labels map[string]string) bool {
+	for k, v := range sel.MatchLabels {
+		if actual, ok := labels[k]; !ok || actual != v {
+			return false
+		}
+	}
+	for _, expr := range sel.MatchExpressions {
+		actual, exists := labels[expr.Key]
+		contained := false
+		for _, v := range expr.Values {
+			if v == actual {
+				contained = true
+			}
+		}
+		switch expr.Operator {
+		case "In":
+			if !exists || !contained {
+				return false
+			}
+		case "NotIn":
+			if exists && contained {
+				return false
+			}
+		case "Exists":
+			if !exists {
+				return false
+			}
+		case "DoesNotExist":
+			if exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (nwPol NetworkPolicy) hasPolicyType(policyType string) bool {
+	if len(nwPol.Spec.PolicyTypes) == 0 {
+		// default policy types as defined by the NetworkPolicy api
+		return policyType == "Ingress" || (policyType == "Egress" && len(nwPol.Spec.Egress) > 0)
+	}
+	for _, t := range nwPol.Spec.PolicyTypes {
+		if t == policyType {
+			return true
+		}
+	}
+	return false
+}
+
+func (nwPol NetworkPolicy) selects(pod NetworkEndpoint) bool {
+	return nwPol.Metadata.Namespace == pod.Namespace && nwPol.Spec.PodSelector.Matches(pod.Labels)
+}
+
+func (nwPol NetworkPolicy) String() string {
+	return nwPol.Metadata.Namespace + "/" + nwPol.Metadata.Name
+}
+
+func EvaluateEgress(policies []NetworkPolicy, src, dst NetworkEndpoint, protocol string, port int) NetworkPolicyVerdict {
+	return evaluateNetworkPolicies(policies, "Egress", src, dst, dst, protocol, port)
+}
+
+func EvaluateIngress(policies []NetworkPolicy, src, dst NetworkEndpoint, protocol string, port int) NetworkPolicyVerdict {
+	return evaluateNetworkPolicies(policies, "Ingress", dst, src, dst, protocol, port)
+}
+
+// pod is the endpoint selected by the policies, peer the other side of the connection and target the endpoint that owns the port
+func evaluateNetworkPolicies(policies []NetworkPolicy, policyType string, pod, peer, target NetworkEndpoint, protocol string, port int) NetworkPolicyVerdict {
+	var verdict NetworkPolicyVerdict
+	for _, nwPol := range policies {
+		if !nwPol.selects(pod) || !nwPol.hasPolicyType(policyType) {
+			continue
+		}
+		verdict.Isolated = true
+		verdict.SelectingPolicies = append(verdict.SelectingPolicies, nwPol.String())
+
+		rules := nwPol.Spec.Ingress
+		if policyType == "Egress" {
+			rules = nwPol.Spec.Egress
+		}
+		for i, rule := range rules {
+			peers := rule.From
+			if policyType == "Egress" {
+				peers = rule.To
+			}
+			if !verdict.Allowed && matchesPeers(peers, nwPol.Metadata.Namespace, peer) && matchesPorts(rule.Ports, target, protocol, port) {
+				verdict.Allowed = true
+				verdict.AllowedBy = fmt.Sprintf("%s %s rule #%d", nwPol.String(), strings.ToLower(policyType), i+1)
+			}
+		}
+	}
+	if !verdict.Isolated {
+		verdict.Allowed = true
+	}
+	return verdict
+}
+
+func matchesPeers(peers []NetworkPolicyPeer, policyNamespace string, peer NetworkEndpoint) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, p := range peers {
+		if p.IPBlock != nil {
+			if ipInCIDRs(peer.IP, []string{p.IPBlock.CIDR}) && !ipInCIDRs(peer.IP, p.IPBlock.Except) {
+				return true
+			}
+			continue
+		}
+		if p.NamespaceSelector != nil {
+			if !p.NamespaceSelector.Matches(peer.NamespaceLabels) {
+				continue
+			}
+		} else if peer.Namespace != policyNamespace {
+			continue
+		}
+		if p.PodSelector != nil && !p.PodSelector.Matches(peer.Labels) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func matchesPorts(ports []NetworkPolicyPort, target NetworkEndpoint, protocol string, port int) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		ruleProtocol := p.Protocol
+		if len(ruleProtocol) == 0 {
+			ruleProtocol = "TCP"
+		}
+		if !strings.EqualFold(ruleProtocol, protocol) {
+			continue
+		}
+		switch rulePort := p.Port.(type) {
+		case nil:
+			return true
+		case float64:
+			if int(rulePort) == port || (p.EndPort > 0 && int(rulePort) <= port && port <= p.EndPort) {
+				return true
+			}
+		case string:
+			if n, err := strconv.Atoi(rulePort); err == nil {
+				if n == port {
+					return true
+				}
+			} else if namedPort, ok := target.NamedPorts[rulePort]; ok && namedPort == port {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ipInCIDRs(ip string, cidrs []string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err == nil && ipNet.Contains(parsedIP) {
+			return true
+		}
+	}
+	return false
+}
+
+func FormatNetworkPolicyVerdict(verdict NetworkPolicyVerdict) string {
+	switch {
+	case !verdict.Isolated:
+		return "ALLOWED (not selected by any NetworkPolicy)"
+	case verdict.Allowed:
+		return "ALLOWED by " + verdict.AllowedBy
+	default:
+		return "BLOCKED (selected by " + strings.Join(verdict.SelectingPolicies, ", ") + " but no rule matches)"
+	}
+}
+
+var netpolTargetPattern = regexp.MustCompile(`^(svc|service|po|pod)/([a-z0-9.\-]+):([0-9]+)$`)
+
+func ParseNetworkPolicyTarget(str string) (kind, name string, port int, err error) {
+	m := netpolTargetPattern.FindStringSubmatch(str)
+	if m == nil {
+		return "", "", 0, usageErrorf("target must be like svc/name:port or pod/name:port, got %q instead", str)
+	}
+	kind = "pod"
+	if m[1] == "svc" || m[1] == "service" {
+		kind = "svc"
+	}
+	port, err = strconv.Atoi(m[3])
+	if err != nil || port < 1 || port > 65535 {
+		return "", "", 0, usageErrorf("invalid port %q", m[3])
+	}
+	return kind, m[2], port, nil
+}
+
+func MakeEgressRuleTo(dst NetworkEndpoint, podSelector map[string]string, protocol string, port int) EgressBlock {
+	return EgressBlock{
+		Ports: []PortBlock{{Protocol: strings.ToUpper(protocol), Port: port}},
+		To: []PeerBlock{{
+			NamespaceSelector: &LabelSelectorBlock{MatchLabels: map[string]string{"kubernetes.io/metadata.name": dst.Namespace}},
+			PodSelector:       &LabelSelectorBlock{MatchLabels: podSelector},
+		}},
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateNetworkPolicies(t *testing.T) {
+	var policies []NetworkPolicy
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"metadata": {"name": "default-deny", "namespace": "dev"}, "spec": {"podSelector": {}, "policyTypes": ["Ingress", "Egress"]}},
+		{"metadata": {"name": "allow-dns", "namespace": "dev"}, "spec": {"podSelector": {}, "egress": [{"ports": [{"protocol": "UDP", "port": 53}]}], "policyTypes": ["Egress"]}},
+		{"metadata": {"name": "api-ingress", "namespace": "shop"}, "spec": {
+			"podSelector": {"matchLabels": {"app": "api"}},
+			"ingress": [{"from": [{"namespaceSelector": {"matchLabels": {"team": "dev"}}, "podSelector": {"matchExpressions": [{"key": "app.kubernetes.io/name", "operator": "In", "values": ["go-testpod"]}]}}], "ports": [{"port": "http"}]}]
+		}}
+	]`), &policies))
+
+	src := NetworkEndpoint{Name: "testpod-foo", Namespace: "dev", NamespaceLabels: map[string]string{"team": "dev"}, Labels: map[string]string{"app.kubernetes.io/name": "go-testpod"}, IP: "10.0.0.5"}
+	dst := NetworkEndpoint{Name: "api-123", Namespace: "shop", NamespaceLabels: map[string]string{"team": "shop"}, Labels: map[string]string{"app": "api"}, IP: "10.0.1.7", NamedPorts: map[string]int{"http": 8080}}
+
+	egress := EvaluateEgress(policies, src, dst, "TCP", 8080)
+	require.True(t, egress.Isolated)
+	require.False(t, egress.Allowed)
+	require.Equal(t, []string{"dev/default-deny", "dev/allow-dns"}, egress.SelectingPolicies)
+
+	ingress := EvaluateIngress(policies, src, dst, "TCP", 8080)
+	require.True(t, ingress.Allowed)
+	require.Equal(t, "shop/api-ingress ingress rule #1", ingress.AllowedBy)
+
+	require.False(t, EvaluateIngress(policies, src, dst, "TCP", 9090).Allowed)
+	require.False(t, EvaluateIngress(policies, src, dst, "UDP", 8080).Allowed)
+
+	dns := EvaluateEgress(policies, src, NetworkEndpoint{Namespace: "kube-system", IP: "10.96.0.10"}, "UDP", 53)
+	require.True(t, dns.Allowed)
+	require.Equal(t, "dev/allow-dns egress rule #1", dns.AllowedBy)
+
+	// adding the suggested rule to an own policy unblocks egress
+	rule := MakeEgressRuleTo(dst, map[string]string{"app": "api"}, "TCP", 8080)
+	dst.NamespaceLabels["kubernetes.io/metadata.name"] = "shop"
+	var own NetworkPolicy
+	own.Metadata.Name = "testpod-foo"
+	own.Metadata.Namespace = "dev"
+	own.Spec.PolicyTypes = []string{"Egress"}
+	own.Spec.Egress = []NetworkPolicyRule{{
+		Ports: []NetworkPolicyPort{{Protocol: rule.Ports[0].Protocol, Port: float64(rule.Ports[0].Port)}},
+		To:    []NetworkPolicyPeer{{NamespaceSelector: &LabelSelector{MatchLabels: rule.To[0].NamespaceSelector.MatchLabels}, PodSelector: &LabelSelector{MatchLabels: rule.To[0].PodSelector.MatchLabels}}},
+	}}
+	require.True(t, EvaluateEgress(append(policies, own), src, dst, "TCP", 8080).Allowed)
+
+	require.True(t, EvaluateEgress(nil, src, dst, "TCP", 8080).Allowed)
+	require.False(t, EvaluateEgress(nil, src, dst, "TCP", 8080).Isolated)
+}
+
+func TestParseNetworkPolicyTarget(t *testing.T) {
+	kind, name, port, err := ParseNetworkPolicyTarget("svc/foo:8080")
+	require.NoError(t, err)
+	require.Equal(t, "svc", kind)
+	require.Equal(t, "foo", name)
+	require.Equal(t, 8080, port)
+
+	kind, _, _, err = ParseNetworkPolicyTarget("pod/foo-123:80")
+	require.NoError(t, err)
+	require.Equal(t, "pod", kind)
+
+	_, _, _, err = ParseNetworkPolicyTarget("deployment/foo:80")
+	require.Error(t, err)
+	_, _, _, err = ParseNetworkPolicyTarget("svc/foo")
+	require.Error(t, err)
+	_, _, _, err = ParseNetworkPolicyTarget("svc/foo:70000")
+	require.Error(t, err)
+}
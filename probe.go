@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	ProbeDNS  = "dns"
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+	ProbeTLS  = "tls"
+)
+
+type ProbeResult struct {
+	Kind           string  `json:"kind"`
+	Target         string  `json:"target"`
+	Success        bool    `json:"success"`
+	Error          string  `json:"error,omitempty"`
+	DurationMillis float64 `json:"durationMillis"`
+	// Addresses are the resolved addresses of the target host
+	Addresses     []string          `json:"addresses,omitempty"`
+	CNAME         string            `json:"cname,omitempty"`
+	RemoteAddress string            `json:"remoteAddress,omitempty"`
+	ConnectMillis float64           `json:"connectMillis,omitempty"`
+	HTTP          *HTTPProbeResult  `json:"http,omitempty"`
+	TLS           *TLSProbeResult   `json:"tls,omitempty"`
+	Resolver      *ResolverSettings `json:"resolver,omitempty"`
+}
+
+type ResolverSettings struct {
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+}
+
+type HTTPProbeResult struct {
+	StatusCode int    `json:"statusCode"`
+	Status     string `json:"status"`
+	Proto      string `json:"proto"`
+	Server     string `json:"server,omitempty"`
+	// FirstByteMillis is the time from sending the request until the response headers arrived
+	FirstByteMillis float64 `json:"firstByteMillis"`
+}
+
+type TLSProbeResult struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipherSuite"`
+	ServerName  string `json:"serverName"`
+	// VerifyError is empty if the chain is trusted by the system roots of the pod and matches the server name
+	VerifyError  string                `json:"verifyError,omitempty"`
+	Certificates []TLSCertificateProbe `json:"certificates"`
+}
+
+type TLSCertificateProbe struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dnsNames,omitempty"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// RunProbe is executed by the agent inside the pod and only relies on the go standard library
+func RunProbe(kind, target string, timeout time.Duration) ProbeResult {
+	result := ProbeResult{Kind: kind, Target: target}
+	start := time.Now()
+	var err error
+	switch kind {
+	case ProbeDNS:
+		err = probeDNS(&result, target, timeout)
+	case ProbeTCP:
+		err = probeTCP(&result, target, timeout)
+	case ProbeHTTP:
+		err = probeHTTP(&result, target, timeout)
+	case ProbeTLS:
+		err = probeTLS(&result, target, timeout)
+	default:
+		err = fmt.Errorf("unknown probe %q", kind)
+	}
+	result.DurationMillis = millisSince(start)
+	result.Success = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func millisSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+// goResolver does not depend on libc or nss in the pod
+var goResolver = &net.Resolver{PreferGo: true}
+
+func probeDNS(result *ProbeResult, host string, timeout time.Duration) error {
+	result.Resolver = readResolverSettings("/etc/resolv.conf")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := goResolver.LookupHost(ctx, host)
+	if err != nil {
+		return err
+	}
+	result.Addresses = addrs
+	if cname, err := goResolver.LookupCNAME(ctx, host); err == nil && strings.TrimSuffix(cname, ".") != strings.TrimSuffix(host, ".") {
+		result.CNAME = cname
+	}
+	return nil
+}
+
+func readResolverSettings(path string) *ResolverSettings {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return ParseResolvConf(string(data))
+}
+
+func ParseResolvConf(str string) *ResolverSettings {
+	settings := &ResolverSettings{Nameservers: []string{}, Search: []string{}}
+	for _, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			settings.Nameservers = append(settings.Nameservers, fields[1])
+		case "search":
+			settings.Search = fields[1:]
+		}
+	}
+	return settings
+}
+
+// dialProbe resolves the address first, so resolution and connect latency are reported separately
+func dialProbe(result *ProbeResult, address string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := goResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	result.Addresses = addrs
+
+	dialer := net.Dialer{Timeout: timeout, Resolver: goResolver}
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", net.JoinHostPort(addrs[0], port))
+	if err != nil {
+		return nil, err
+	}
+	result.ConnectMillis = millisSince(start)
+	result.RemoteAddress = conn.RemoteAddr().String()
+	return conn, nil
+}
+
+func probeTCP(result *ProbeResult, address string, timeout time.Duration) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("target must be like host:port")
+	}
+	conn, err := dialProbe(result, address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func probeHTTP(result *ProbeResult, target string, timeout time.Duration) error {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	var connectStart time.Time
+	dialer := &net.Dialer{Timeout: timeout, Resolver: goResolver}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			connectStart = time.Now()
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err == nil {
+				result.ConnectMillis = millisSince(connectStart)
+				result.RemoteAddress = conn.RemoteAddr().String()
+			}
+			return conn, err
+		},
+		// certificates are checked by the tls probe, http only reports whether the endpoint answers
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	client := http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// report redirects instead of following them
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	result.HTTP = &HTTPProbeResult{
+		StatusCode:      resp.StatusCode,
+		Status:          resp.Status,
+		Proto:           resp.Proto,
+		Server:          resp.Header.Get("Server"),
+		FirstByteMillis: millisSince(start),
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("server responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func probeTLS(result *ProbeResult, address string, timeout time.Duration) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "443")
+	}
+	serverName, _, _ := net.SplitHostPort(address)
+
+	conn, err := dialProbe(result, address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	// verify manually after the handshake, so the chain is also reported for untrusted certificates
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("tls handshake: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	result.TLS = &TLSProbeResult{
+		Version:      tls.VersionName(state.Version),
+		CipherSuite:  tls.CipherSuiteName(state.CipherSuite),
+		ServerName:   serverName,
+		Certificates: make([]TLSCertificateProbe, 0, len(state.PeerCertificates)),
+	}
+	for _, cert := range state.PeerCertificates {
+		result.TLS.Certificates = append(result.TLS.Certificates, TLSCertificateProbe{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+		})
+	}
+	if err := verifyPeerCertificates(state.PeerCertificates, serverName); err != nil {
+		result.TLS.VerifyError = err.Error()
+		return fmt.Errorf("verify certificate: %w", err)
+	}
+	return nil
+}
+
+func verifyPeerCertificates(certs []*x509.Certificate, serverName string) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates presented")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+	return err
+}
+
+func (r ProbeResult) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&r)
+}
+
+func (r ProbeResult) Write(w io.Writer, now time.Time) error {
+	status := "OK"
+	if !r.Success {
+		status = "FAILED"
+	}
+	fmt.Fprintf(w, "%s probe of %s: %s\n", strings.ToUpper(r.Kind), r.Target, status)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if len(r.Error) > 0 {
+		fmt.Fprintf(tw, "Error:\t%s\n", r.Error)
+	}
+	if r.Resolver != nil {
+		fmt.Fprintf(tw, "Nameservers:\t%s\n", orDash(strings.Join(r.Resolver.Nameservers, ", ")))
+		fmt.Fprintf(tw, "Search:\t%s\n", orDash(strings.Join(r.Resolver.Search, " ")))
+	}
+	if len(r.CNAME) > 0 {
+		fmt.Fprintf(tw, "CNAME:\t%s\n", r.CNAME)
+	}
+	if len(r.Addresses) > 0 {
+		fmt.Fprintf(tw, "Addresses:\t%s\n", strings.Join(r.Addresses, ", "))
+	}
+	if len(r.RemoteAddress) > 0 {
+		fmt.Fprintf(tw, "Connected to:\t%s in %.1fms\n", r.RemoteAddress, r.ConnectMillis)
+	}
+	if r.HTTP != nil {
+		fmt.Fprintf(tw, "Status:\t%s (%s)\n", r.HTTP.Status, r.HTTP.Proto)
+		if len(r.HTTP.Server) > 0 {
+			fmt.Fprintf(tw, "Server:\t%s\n", r.HTTP.Server)
+		}
+		fmt.Fprintf(tw, "First byte:\t%.1fms\n", r.HTTP.FirstByteMillis)
+	}
+	if r.TLS != nil {
+		fmt.Fprintf(tw, "TLS:\t%s, %s\n", r.TLS.Version, r.TLS.CipherSuite)
+		if len(r.TLS.VerifyError) == 0 {
+			fmt.Fprintf(tw, "Verified:\tyes, for %s\n", r.TLS.ServerName)
+		} else {
+			fmt.Fprintf(tw, "Verified:\tno, %s\n", r.TLS.VerifyError)
+		}
+		for i, cert := range r.TLS.Certificates {
+			expiry := fmt.Sprintf("expires %s (in %s)", cert.NotAfter.Format("2006-01-02"), FormatDuration(cert.NotAfter.Sub(now)))
+			if now.After(cert.NotAfter) {
+				expiry = fmt.Sprintf("EXPIRED %s (%s ago)", cert.NotAfter.Format("2006-01-02"), FormatDuration(now.Sub(cert.NotAfter)))
+			}
+			fmt.Fprintf(tw, "Certificate %d:\t%s\n", i, cert.Subject)
+			fmt.Fprintf(tw, "\tissued by %s\n", cert.Issuer)
+			if len(cert.DNSNames) > 0 {
+				fmt.Fprintf(tw, "\tnames %s\n", strings.Join(cert.DNSNames, ", "))
+			}
+			fmt.Fprintf(tw, "\t%s\n", expiry)
+		}
+	}
+	fmt.Fprintf(tw, "Duration:\t%.1fms\n", r.DurationMillis)
+	return tw.Flush()
+}
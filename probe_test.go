@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	settings := ParseResolvConf("# generated\nsearch default.svc.cluster.local svc.cluster.local\nnameserver 10.96.0.10\noptions ndots:5\n")
+	require.Equal(t, &ResolverSettings{
+		Nameservers: []string{"10.96.0.10"},
+		Search:      []string{"default.svc.cluster.local", "svc.cluster.local"},
+	}, settings)
+}
+
+func TestRunProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	result := RunProbe(ProbeHTTP, address, time.Second)
+	require.True(t, result.Success, result.Error)
+	require.Equal(t, http.StatusTeapot, result.HTTP.StatusCode)
+
+	result = RunProbe(ProbeTCP, address, time.Second)
+	require.True(t, result.Success, result.Error)
+	require.Equal(t, address, result.RemoteAddress)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := listener.Addr().String()
+	listener.Close()
+	result = RunProbe(ProbeTCP, closedAddress, time.Second)
+	require.False(t, result.Success)
+	require.NotEmpty(t, result.Error)
+
+	result = RunProbe(ProbeTCP, "missing-port", time.Second)
+	require.False(t, result.Success)
+}
+
+func TestWriteProbeResult(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	result := ProbeResult{
+		Kind:           ProbeTLS,
+		Target:         "example.com",
+		Success:        false,
+		Error:          "verify certificate: x509: certificate has expired or is not yet valid",
+		DurationMillis: 12.34,
+		Addresses:      []string{"93.184.216.34"},
+		RemoteAddress:  "93.184.216.34:443",
+		ConnectMillis:  5.5,
+		TLS: &TLSProbeResult{
+			Version:     "TLS 1.3",
+			CipherSuite: "TLS_AES_128_GCM_SHA256",
+			ServerName:  "example.com",
+			VerifyError: "x509: certificate has expired or is not yet valid",
+			Certificates: []TLSCertificateProbe{
+				{Subject: "CN=example.com", Issuer: "CN=Some CA", NotAfter: now.Add(-48 * time.Hour)},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, result.Write(&buf, now))
+	require.Contains(t, buf.String(), "TLS probe of example.com: FAILED\n")
+	require.Contains(t, buf.String(), "Connected to:   93.184.216.34:443 in 5.5ms\n")
+	require.Contains(t, buf.String(), "EXPIRED 2026-10-16 (2d ago)\n")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type Permission struct {
+	Resource        string
+	NonResourceURLs []string
+	ResourceNames   []string
+	Verbs           []string
+}
+
+var (
+	canIListLinePattern = regexp.MustCompile(`^(\S*)\s+\[([^\]]*)\]\s+\[([^\]]*)\]\s+\[([^\]]*)\]\s*$`)
+	matrixVerbs         = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+)
+
+func ParseCanIList(out string) ([]Permission, error) {
+	permissions := make([]Permission, 0)
+	for _, line := range strings.Split(out, "\n") {
+		// warnings like "the list may be incomplete" of webhook authorizers are no permissions
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "Resources") || strings.HasPrefix(line, "Warning:") {
+			continue
+		}
+		m := canIListLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		permissions = append(permissions, Permission{
+			Resource:        m[1],
+			NonResourceURLs: strings.Fields(m[2]),
+			ResourceNames:   strings.Fields(m[3]),
+			Verbs:           strings.Fields(m[4]),
+		})
+	}
+	return permissions, nil
+}
+
+func WritePermissionMatrix(out io.Writer, permissions []Permission) error {
+	type row struct {
+		Name  string
+		Verbs map[string]bool
+	}
+	rows := make([]row, 0, len(permissions))
+	for _, p := range permissions {
+		names := []string{p.Resource}
+		if len(p.Resource) == 0 {
+			names = p.NonResourceURLs
+		} else if len(p.ResourceNames) > 0 {
+			names = []string{p.Resource + " [" + strings.Join(p.ResourceNames, " ") + "]"}
+		}
+		verbs := make(map[string]bool)
+		for _, v := range p.Verbs {
+			verbs[v] = true
+		}
+		for _, name := range names {
+			rows = append(rows, row{Name: name, Verbs: verbs})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	header := []string{"RESOURCE"}
+	for _, v := range matrixVerbs {
+		header = append(header, strings.ToUpper(v))
+	}
+	header = append(header, "OTHER")
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, r := range rows {
+		cells := []string{r.Name}
+		for _, v := range matrixVerbs {
+			if r.Verbs[v] || r.Verbs["*"] {
+				cells = append(cells, "x")
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+		other := make([]string, 0)
+		for v := range r.Verbs {
+			isMatrixVerb := v == "*"
+			for _, mv := range matrixVerbs {
+				if v == mv {
+					isMatrixVerb = true
+				}
+			}
+			if !isMatrixVerb {
+				other = append(other, v)
+			}
+		}
+		if r.Verbs["*"] {
+			other = append(other, "*")
+		}
+		sort.Strings(other)
+		if len(other) == 0 {
+			other = append(other, "-")
+		}
+		cells = append(cells, strings.Join(other, ","))
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
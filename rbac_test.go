@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCanIList(t *testing.T) {
+	out := `Resources                                       Non-Resource URLs                     Resource Names   Verbs
+selfsubjectreviews.authentication.k8s.io        []                                    []               [create]
+configmaps                                      []                                    [my-config]      [get watch]
+pods                                            []                                    []               [*]
+                                                [/healthz]                            []               [get]
+`
+	permissions, err := ParseCanIList(out)
+	require.NoError(t, err)
+	require.Equal(t, []Permission{
+		{Resource: "selfsubjectreviews.authentication.k8s.io", NonResourceURLs: []string{}, ResourceNames: []string{}, Verbs: []string{"create"}},
+		{Resource: "configmaps", NonResourceURLs: []string{}, ResourceNames: []string{"my-config"}, Verbs: []string{"get", "watch"}},
+		{Resource: "pods", NonResourceURLs: []string{}, ResourceNames: []string{}, Verbs: []string{"*"}},
+		{Resource: "", NonResourceURLs: []string{"/healthz"}, ResourceNames: []string{}, Verbs: []string{"get"}},
+	}, permissions)
+
+	var buf bytes.Buffer
+	require.NoError(t, WritePermissionMatrix(&buf, permissions))
+	require.Equal(t, `RESOURCE                                  GET  LIST  WATCH  CREATE  UPDATE  PATCH  DELETE  DELETECOLLECTION  OTHER
+/healthz                                  x    -     -      -       -       -      -       -                 -
+configmaps [my-config]                    x    -     x      -       -       -      -       -                 -
+pods                                      x    x     x      x       x       x      x       x                 *
+selfsubjectreviews.authentication.k8s.io  -    -     -      x       -       -      -       -                 -
+`, buf.String())
+
+	permissions, err = ParseCanIList("Warning: the list may be incomplete: webhook authorizer does not support user rule resolution\n" + out)
+	require.NoError(t, err)
+	require.Len(t, permissions, 4)
+
+	_, err = ParseCanIList("garbage line\n")
+	require.Error(t, err)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/manifoldco/promptui"
@@ -39,3 +42,76 @@ func FormatDuration(d time.Duration) string {
 		return fmt.Sprintf("%ds", int(d.Seconds()))
 	}
 }
+
+func InteractiveConfirm(label string) (bool, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		if errors.Is(err, promptui.ErrAbort) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func InteractiveConfirmByTyping(label, expected string) error {
+	prompt := promptui.Prompt{
+		Label: label,
+		Validate: func(input string) error {
+			if input != expected {
+				return fmt.Errorf("input does not match %q", expected)
+			}
+			return nil
+		},
+	}
+	_, err := prompt.Run()
+	return err
+}
+
+func FindSimilarNames(name string, candidates []string) []string {
+	similar := make([]string, 0)
+	lowerName := strings.ToLower(name)
+	for _, c := range candidates {
+		lowerCandidate := strings.ToLower(c)
+		maxDistance := len(name) / 3
+		if maxDistance < 2 {
+			maxDistance = 2
+		}
+		if strings.Contains(lowerCandidate, lowerName) || strings.Contains(lowerName, lowerCandidate) || levenshteinDistance(lowerName, lowerCandidate) <= maxDistance {
+			similar = append(similar, c)
+		}
+	}
+	return similar
+}
+
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindSimilarNames(t *testing.T) {
+	contexts := []string{"prod-eu", "prod-us", "staging-eu", "kind-local"}
+	require.Equal(t, []string{"prod-eu"}, FindSimilarNames("prd-eu", contexts))
+	require.Equal(t, []string{"prod-eu", "prod-us"}, FindSimilarNames("prod", contexts))
+	require.Equal(t, []string{"staging-eu"}, FindSimilarNames("Staging-EU", contexts))
+	require.Empty(t, FindSimilarNames("minikube", contexts))
+}